@@ -4,11 +4,26 @@
 package deep
 
 import (
+	"bytes"
+	"container/heap"
+	"encoding"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/big"
+	"net/textproto"
 	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -21,20 +36,60 @@ var (
 	// ErrNotHandled is logged when a primitive Go kind is not handled.
 	ErrNotHandled = errors.New("cannot compare the reflect.Kind")
 
+	// ErrPanic is returned by CompareE when comparing the values panics.
+	ErrPanic = errors.New("panic while comparing")
+
+	// ErrTimeout is logged when the comparison takes longer than Timeout.
+	ErrTimeout = errors.New("comparison exceeded Timeout")
+
+	// ErrMapTruncated is logged when a map has more than MaxMapKeys keys.
+	ErrMapTruncated = errors.New("map keys truncated to MaxMapKeys")
+
+	// ErrSliceTruncated is logged when a slice or array has more than
+	// MaxSliceElems elements.
+	ErrSliceTruncated = errors.New("slice elements truncated to MaxSliceElems")
+
 	DefaultOptions = Options{
 		FloatPrecision:          10,
 		MaxDiff:                 10,
 		MaxDepth:                10,
 		LogErrors:               false,
 		CompareUnexportedFields: false,
+		PathSeparator:           ".",
 	}
 )
 
+// PathRule is how values at a path are compared, set by Options.PathRules.
+type PathRule struct {
+	// CaseInsensitive causes strings to be compared ignoring case when true,
+	// like "usd" and "USD".
+	CaseInsensitive bool
+}
+
+// PathStyle is how paths in diffs are rendered, set by Options.PathStyle.
+type PathStyle int
+
+const (
+	// Dotted paths join names with the PathSeparator, like
+	// "User.Addresses.#0.Tags.env".
+	Dotted PathStyle = iota
+
+	// JQ paths are jq selectors, like ".User.Addresses[0].Tags.env". Map
+	// keys that aren't identifiers are quoted, like .Tags["a.b"].
+	JQ
+
+	// JSONPointer paths are RFC 6901 JSON Pointers, like
+	// "/User/Addresses/0/Tags/env". "~" and "/" in names are escaped as "~0"
+	// and "~1".
+	JSONPointer
+)
+
 type Options struct {
 	// FloatPrecision is the number of decimal places to round float values
 	// to when comparing.
 	FloatPrecision int
-	// MaxDiff specifies the maximum number of differences to return.
+	// MaxDiff specifies the maximum number of differences to return, or
+	// no maximum if zero or less.
 	MaxDiff int
 	// MaxDepth specifies the maximum levels of a struct to recurse into.
 	MaxDepth int
@@ -43,24 +98,528 @@ type Options struct {
 	// CompareUnexportedFields causes unexported struct fields, like s in
 	// T{s int}, to be comparsed when true.
 	CompareUnexportedFields bool
+	// BytesAsNumber causes byte arrays, like [8]byte, to be compared as
+	// big-endian unsigned integers when true, so arrays of different lengths
+	// are equal if they encode the same number.
+	BytesAsNumber bool
+	// NilPointerEqualsZero causes a nil pointer to equal a pointer to a zero
+	// value, like *int(nil) and a pointer to 0, when true.
+	NilPointerEqualsZero bool
+	// PathSeparator is used to join the names in a diff path, like "." in
+	// "Alias.Nickname". If empty, "." is used. Map keys that contain the
+	// separator are quoted and bracketed, like ["a.b"], to keep the path
+	// unambiguous.
+	PathSeparator string
+	// Canonicalizers maps a type to a function that returns the canonical
+	// form of its values, like a lowercased email address. Values of the type
+	// are passed through the function before they are compared.
+	Canonicalizers map[reflect.Type]func(interface{}) interface{}
+	// ContextFields names struct fields, like "ID", whose values are added to
+	// diffs inside the struct to identify it, like "[ID=42] Name: foo != bar".
+	ContextFields []string
+	// ByFieldName causes structs of different types to be compared by the
+	// fields they share, matched by name and kind, when true. Fields in only
+	// one struct are ignored.
+	ByFieldName bool
+	// SkipSyncPrimitives causes struct fields of type sync.Mutex,
+	// sync.RWMutex, sync.Once, and sync.WaitGroup to be skipped when true.
+	// Their internal state, like whether a mutex is locked, is not data.
+	SkipSyncPrimitives bool
+	// CompareBinaryMarshaler causes values that implement
+	// encoding.BinaryMarshaler to be compared by their MarshalBinary output,
+	// instead of their structure, when true. If either value fails to marshal,
+	// their structure is compared.
+	CompareBinaryMarshaler bool
+	// SliceLCS causes slices to be compared by their longest common
+	// subsequence when true, so an inserted or deleted element is one diff
+	// instead of a diff for every element after it. Elements only in b are
+	// reported at their index in b; other diffs are at their index in a.
+	SliceLCS bool
+	// OrderIndependentPaths lists paths, like "Tags" or "Groups.admins", of
+	// slices whose order is ignored. Elements only in one slice are reported
+	// at their index in that slice.
+	OrderIndependentPaths []string
+	// EnumNames maps an integer enum type to the names of its values, like
+	// {1: "Active", 2: "Pending"}, which are shown in diffs instead of the
+	// numbers. Values are still compared by number.
+	EnumNames map[reflect.Type]map[int64]string
+	// Timeout stops the comparison, logging ErrTimeout, if it takes longer
+	// than the duration. Zero means no timeout. Differences found before the
+	// timeout are returned; CompareE also returns ErrTimeout.
+	Timeout time.Duration
+	// AutoDerefMixed causes a pointer to be dereferenced when it's compared
+	// to a value of its element type, like *User and User, when true. Maps
+	// of pointers and maps of their element type, like map[string]*int and
+	// map[string]int, are compared by key like maps of the same type.
+	AutoDerefMixed bool
+	// Redact lists paths, like "Meta.CreatedAt", of volatile values that are
+	// never compared. Unlike a field skipped by a struct tag, a redacted value
+	// must still exist on both sides, have the same type, and be nil on both
+	// sides or neither.
+	Redact []string
+	// CompareAtomics causes sync/atomic types, like atomic.Int64 and
+	// atomic.Value, to be compared by the values their Load methods return
+	// when true. Otherwise, their unexported fields are not compared.
+	CompareAtomics bool
+	// DeterministicMapOrder causes map keys to be compared in order of their
+	// "%v" string when true, so diffs are returned in the same order every
+	// time, even for keys of unordered kinds like structs.
+	DeterministicMapOrder bool
+	// NumericKindInsensitive causes numbers of different types, like int 1
+	// and float64 1.0, to be compared by value when true.
+	NumericKindInsensitive bool
+	// NilSliceEqualsEmpty causes a nil slice to equal an empty slice when
+	// true.
+	NilSliceEqualsEmpty bool
+	// IgnoreValue, if set, is called for every pair of compared values that
+	// can be interfaced, with the path to them, and the pair is skipped if it
+	// returns true. It must not modify path.
+	IgnoreValue func(path []string, a, b interface{}) bool
+	// RunesAsString causes slices of runes to be compared as strings when
+	// true, reporting one diff like "héllo != hello" instead of one diff per
+	// differing rune.
+	RunesAsString bool
+	// OnEnter and OnLeave, if set, are called with the path when the
+	// comparison enters and leaves each struct field, map key, and slice or
+	// array element. They must not modify path.
+	OnEnter func(path []string)
+	OnLeave func(path []string)
+	// DurationTolerance, if greater than zero, is how much two time.Duration
+	// values can differ and still be equal.
+	DurationTolerance time.Duration
+	// MapMissingKeyEqualsZero causes a map key in only one map to be equal
+	// when true if its value is the zero value, like "x" in {"x": 0} and {}.
+	MapMissingKeyEqualsZero bool
+	// SortSlicesBeforeCompare causes slices to be sorted, without modifying
+	// them, before their elements are compared by index when true. Elements
+	// of number and string kinds are sorted in their natural order, and
+	// elements of other types by their SliceLess func. Slices of other types
+	// are not sorted.
+	SortSlicesBeforeCompare bool
+	// SliceLess are funcs, by slice element type, that return true if
+	// element i sorts before element j for SortSlicesBeforeCompare.
+	SliceLess map[reflect.Type]func(i, j interface{}) bool
+	// LeafDiffsOnly causes values in only a or b, like a nil pointer or a
+	// missing map key, to be compared to the zero value of their type when
+	// true, so diffs are only reported for the primitive values in them
+	// instead of for the whole missing value.
+	LeafDiffsOnly bool
+	// CompareReaderContent causes values of the ReaderTypes, which must
+	// implement io.Reader, to be compared by reading them fully and comparing
+	// the bytes read, reported as strings, when true. Reading consumes the
+	// readers, so they must be reset, like with bytes.Reader.Seek, to be read
+	// again.
+	CompareReaderContent bool
+	// ReaderTypes are the types, like *bytes.Reader, compared by content
+	// with CompareReaderContent.
+	ReaderTypes map[reflect.Type]bool
+	// StringIgnoreWhitespace causes strings to be compared with leading and
+	// trailing whitespace trimmed and other runs of whitespace collapsed to
+	// one space when true. Diffs show the original strings.
+	StringIgnoreWhitespace bool
+	// FloatExact causes floats to be compared bit for bit, ignoring
+	// FloatPrecision, when true, so 0.1+0.2 and 0.3 differ, and so do 0.0 and
+	// -0.0. NaNs are equal to each other, as they are when FloatPrecision is
+	// used, whatever their bits.
+	FloatExact bool
+	// TraceSkips causes the values that are not compared, like unexported
+	// struct fields, to be recorded with the reason when true. They are
+	// returned by CompareTrace.
+	TraceSkips bool
+	// StringTrimPrefixes and StringTrimSuffixes are trimmed, in order, from
+	// strings before they are compared, so "prod-service-a" equals
+	// "service-a" if "prod-" is a prefix. Diffs show the original strings.
+	StringTrimPrefixes []string
+	StringTrimSuffixes []string
+	// SortOutput causes diffs, patch operations, and reports to be returned
+	// sorted by path when true instead of in the order they were found.
+	SortOutput bool
+	// CompareViaGetters causes values of the GetterTypes to be compared by
+	// the results of their exported methods named Get*, like GetName, that
+	// take no arguments and return one value, instead of by their fields,
+	// when true. Getters that panic are skipped.
+	CompareViaGetters bool
+	// GetterTypes are the types, like *User, compared by getters with
+	// CompareViaGetters.
+	GetterTypes map[reflect.Type]bool
+	// SoftTypeMismatch causes values of different types but compatible
+	// kinds, like type A string and type B string, or int and float64, to be
+	// compared by value when true. Differing values are reported with their
+	// types, like "foo (pkg.A) != bar (pkg.B)". Values of incompatible kinds
+	// are a type mismatch.
+	SoftTypeMismatch bool
+	// UseDiffable causes values that implement Diffable to be compared by
+	// their Diff method when true.
+	UseDiffable bool
+	// PathStyle is how paths in diffs are rendered. Paths in options, like
+	// Redact, are always Dotted.
+	PathStyle PathStyle
+	// IgnoreTag, if set, is the name of a struct tag, like "audit", that
+	// causes fields with the tag, like `audit:"ignore"`, to be skipped
+	// whatever its value.
+	IgnoreTag string
+	// CompareHTTPMaps causes url.Values and http.Header values to be
+	// compared semantically when true: the values of each key are compared
+	// ignoring their order, and http.Header keys are compared ignoring case,
+	// like http.Header.Get.
+	CompareHTTPMaps bool
+	// EnumByName causes values of integer kinds that implement fmt.Stringer,
+	// like enums, to be compared by their String method when true, even if
+	// their types differ, so pkgA.StatusActive equals pkgB.StatusActive.
+	EnumByName bool
+	// MaxDiffPerPath, if greater than zero, is the maximum number of
+	// differences in the values of one struct, map, slice, or array, so one
+	// container with many differences doesn't use all of MaxDiff. The
+	// differences in a nested container count only toward its own maximum.
+	MaxDiffPerPath int
+	// IgnoreBothZero causes differences where both values are zero values,
+	// or missing, like a missing map key and a zero value, to be ignored
+	// when true.
+	IgnoreBothZero bool
+	// CompareAsInterface are interface types. Values of different types that
+	// both implement one of them are compared by the results of the
+	// interface's methods that take no arguments and return one value,
+	// named by the method name, instead of being a type mismatch.
+	CompareAsInterface []reflect.Type
+	// DumpOnMismatch causes the compared values to be formatted in full, by
+	// ValueFormatter, when their types differ when true, like
+	// "pkg.T {...} != map[string]int {...}". Only the top-level values are
+	// dumped.
+	DumpOnMismatch bool
+	// ValueFormatter, if set, formats values for DumpOnMismatch. By default,
+	// values are formatted as indented JSON, or like "%+v" if they can't be.
+	ValueFormatter func(v interface{}) string
+	// NumericNoiseFloor, if greater than zero, is the smallest difference
+	// between two ints, uints, or floats that is not ignored, so differences
+	// below it are treated as noise. Floats are compared with FloatPrecision
+	// first.
+	NumericNoiseFloor float64
+	// ArraySliceInterchangeable causes an array and a slice of the same
+	// element type, like [3]int and []int, to be compared by index when true
+	// instead of being a type mismatch.
+	ArraySliceInterchangeable bool
+	// GraphEqual causes each pair of pointers to be compared only once when
+	// true, so a graph with shared nodes equals its tree expansion and cyclic
+	// graphs are compared without recursing to MaxDepth. A pair of pointers
+	// seen again, like when a cycle returns to its start, is presumed equal.
+	GraphEqual bool
+	// PreferGoString causes values of types that implement fmt.GoStringer to
+	// be reported by their GoString method when they differ when true, like
+	// "P: point{X: 1, Y: 2} != point{X: 1, Y: 3}", instead of by the fields
+	// that differ.
+	PreferGoString bool
+	// TypedNilEqualsNil causes a nil interface, like error(nil), to equal an
+	// interface holding a nil pointer, like error((*MyError)(nil)), when true.
+	TypedNilEqualsNil bool
+	// DiffRank, if set, ranks diffs by path, like []string{"User", "Status"},
+	// and diffs are returned by rank, highest first. Diffs with the same rank
+	// stay in the order they were found, or sorted by SortOutput. It applies
+	// to diffs returned by CompareS.
+	DiffRank func(path []string) int
+	// MaxMapKeys specifies the maximum number of keys of each map to compare,
+	// or no maximum if zero or less. The smallest keys are compared, by value
+	// for numbers, strings, and bools or else by name, so the same keys are
+	// compared every time, in the order of DeterministicMapOrder.
+	// ErrMapTruncated is logged for maps with more keys.
+	MaxMapKeys int
+	// Comparators maps a type to a function that returns true if two values
+	// of the type are equal, like decimals that are numerically equal, for
+	// types the structure of which should not be compared. They take
+	// precedence over comparators registered with RegisterComparator.
+	Comparators map[reflect.Type]func(a, b interface{}) bool
+	// AnnotateTypes causes diffs returned by CompareS to include the type of
+	// the compared values when true, like "Age (int): 10 != 11".
+	AnnotateTypes bool
+	// MapKeyNormalize, if set, returns the normalized form of a map key, like
+	// a lowercased, trimmed string. Keys of a and b are matched by their
+	// normalized forms, which must be comparable, so "Foo " and "foo" are the
+	// same key. Paths name keys as they are in a, or in b if only in b.
+	MapKeyNormalize func(key interface{}) interface{}
+	// TimeTruncate, if greater than zero, is the granularity to compare
+	// time.Time values at, like time.Second to ignore sub-second differences.
+	// Both times are truncated with Truncate before comparing them with Equal.
+	TimeTruncate time.Duration
+	// JSONValues causes the values in diffs returned by CompareS to be
+	// formatted as JSON when true, like `Address: {"City":"a"} != {"City":"b"}`,
+	// or like "%v" if they can't be marshaled.
+	JSONValues bool
+	// UnwrapSingleField causes structs with one field, like wrapper types such
+	// as type Celsius struct{ v float64 }, to be compared by that field when
+	// true, without the field name in the path, like "Temp: 20 != 21" instead
+	// of "Temp.v: 20 != 21". Unexported fields are unwrapped only if
+	// CompareUnexportedFields is true.
+	UnwrapSingleField bool
+	// CompareSliceCapacity causes slices with different capacities to differ
+	// when true, even if their elements are equal, like "Buf: cap=4 != cap=8".
+	// The elements are compared too.
+	CompareSliceCapacity bool
+	// FastByteSlices causes byte slices, like []byte, to be compared with
+	// bytes.Equal when true. If they differ, only the first difference is
+	// reported: their lengths, like "Data: len=10 != len=12", or else the
+	// first differing byte, like "Data.#5: 1 != 2".
+	FastByteSlices bool
+	// MaxSliceElems specifies the maximum number of elements of each slice or
+	// array to compare by index, or no maximum if zero or less. The first
+	// elements are compared, and ErrSliceTruncated is logged for slices and
+	// arrays with more.
+	MaxSliceElems int
+	// PathRules maps paths, like "Price.Currency", to rules for comparing
+	// the values at them. Paths are Dotted, like for Redact.
+	PathRules map[string]PathRule
+	// NormalizeLineEndings causes "\r\n" and "\r" in strings to be compared
+	// as "\n" when true, so text with Windows and Unix line endings is equal.
+	// Diffs show the original strings.
+	NormalizeLineEndings bool
+	// ZeroTimeEqualsNil causes a nil *time.Time to equal a pointer to the
+	// zero time, time.Time{}, when true.
+	ZeroTimeEqualsNil bool
+	// MapKeysOnly causes maps to be compared by their keys when true, so only
+	// keys in one map but not the other are differences. The values of keys
+	// in both maps are not compared.
+	MapKeysOnly bool
+	// IgnoreField, if set, is called for every compared struct field, and the
+	// field is skipped if it returns true. Unlike IgnoreValue, it is called
+	// only for struct fields, which have their Go names whatever their tags.
+	IgnoreField func(field reflect.StructField) bool
 
-	asMap bool
+	asMap        bool
+	asNested     bool
+	asPatch      bool
+	asReport     bool
+	asGrouped    bool
+	asSchema     bool
+	asCounts     bool
+	firstOnly    bool
+	boolOnly     bool
+	asDivergence bool
 }
 
+// placeholder is a diff value that stands in for a missing value. Its type
+// distinguishes it from a compared string with the same text.
+type placeholder string
+
+const (
+	emptyValue placeholder = "[empty value]"
+	nilPointer placeholder = "<nil pointer>"
+	nilElement placeholder = "<nil>"
+	nilFunc    placeholder = "<nil func>"
+)
+
+// funcValue is the diff value of a non-nil func compared to a nil func.
+const funcValue = "<func>"
+
 type DiffResult struct {
+	// Path is the path to the difference in the PathStyle, or empty if the
+	// compared values themselves differ.
+	Path     string
 	OldValue interface{}
 	NewValue interface{}
+	// Kind is the kind of the compared values, or of the old value if their
+	// types differ.
+	Kind reflect.Kind
+	// Delta is NewValue minus OldValue if both are numbers of the same kind,
+	// else nil. It's an int64 for ints and uints, a float64 for floats, and
+	// a time.Duration for durations.
+	Delta interface{}
+	// TypePath is the type of the value at each level of Path, starting with
+	// the compared values, like [T, Address, string] for "Address.City".
+	// Values of pointers and interfaces have the type of the value they hold.
+	TypePath []reflect.Type
+}
+
+// PatchOp is one operation, shaped like a JSON Patch operation, that
+// transforms a into b. Op is "replace", "add", or "remove". Value is the new
+// value, or nil for "remove".
+type PatchOp struct {
+	Op    string
+	Path  string
+	Value interface{}
+}
+
+// FieldReport is one compared leaf value, like a string field, in the result
+// of Report. Old and New are the values in a and b, and Equal is true if they
+// are equal.
+type FieldReport struct {
+	Path  string
+	Old   interface{}
+	New   interface{}
+	Equal bool
+}
+
+// Skip is a value that was not compared, returned by CompareTrace. Reason is
+// why: "unexported" for unexported struct fields, "sync primitive" for
+// SkipSyncPrimitives, "tag" for fields skipped by their compare tag or
+// IgnoreTag, "ignored" for IgnoreValue and IgnoreField, or "redacted" for
+// Redact.
+type Skip struct {
+	Path   string
+	Reason string
+}
+
+// Diffable is implemented by types that compare themselves with UseDiffable.
+// Diff returns the differences between the value and other, which is the same
+// type, or nil if there are none. The differences are returned by CompareS
+// prefixed with the path to the value, like "User: " + diff.
+type Diffable interface {
+	Diff(other interface{}) []string
+}
+
+// Substring is an expected value that matches strings that contain it,
+// returned by Contains.
+type Substring string
+
+// Contains returns an expected value, b, that equals any string value, a,
+// that contains substr, like:
+//
+//	deep.CompareS(logLine, deep.Contains("connection refused"))
+//
+// It can be used wherever a string can be compared to another type, like the
+// values of interface{} fields and map[string]interface{}.
+func Contains(substr string) Substring {
+	return Substring(substr)
+}
+
+// String returns the substring like Contains("foo").
+func (s Substring) String() string {
+	return fmt.Sprintf("Contains(%q)", string(s))
+}
+
+// SortDiffs sorts diffs by Path so map-mode results, e.g. the values of
+// CompareM, can be output in a stable order.
+func SortDiffs(diffs []DiffResult) {
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+}
+
+// FormatTestify formats diffs returned by CompareS like the failure messages
+// of testify's assert.Equal, with a hunk for each difference:
+//
+//	Diff:
+//	--- Expected
+//	+++ Actual
+//	@@ Name @@
+//	-foo
+//	+bar
+//
+// It returns an empty string if there are no diffs.
+func FormatTestify(diffs []string) string {
+	if len(diffs) == 0 {
+		return ""
+	}
+	var buf strings.Builder
+	buf.WriteString("Diff:\n--- Expected\n+++ Actual\n")
+	for _, d := range diffs {
+		path := ""
+		if i, j := strings.Index(d, ": "), strings.Index(d, " != "); i >= 0 && (j < 0 || i < j) {
+			path, d = d[:i], d[i+2:]
+		}
+		expected, actual := d, ""
+		if j := strings.Index(d, " != "); j >= 0 {
+			expected, actual = d[:j], d[j+4:]
+		}
+		fmt.Fprintf(&buf, "@@ %s @@\n-%s\n+%s\n", path, expected, actual)
+	}
+	return buf.String()
+}
+
+// comparators is the registry of RegisterComparator. It's replaced, not
+// modified, when a comparator is registered, so it can be read without a lock.
+var (
+	comparators   atomic.Value // map[reflect.Type]func(a, b interface{}) bool
+	comparatorsMu sync.Mutex
+)
+
+// RegisterComparator registers fn to compare values of type t in every
+// comparison, like a Comparators entry in every Options. fn returns true if
+// a and b, which are both of type t, are equal. It's safe to call
+// concurrently, but it's meant to be called from package init functions.
+func RegisterComparator(t reflect.Type, fn func(a, b interface{}) bool) {
+	comparatorsMu.Lock()
+	defer comparatorsMu.Unlock()
+	old, _ := comparators.Load().(map[reflect.Type]func(a, b interface{}) bool)
+	m := make(map[reflect.Type]func(a, b interface{}) bool, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[t] = fn
+	comparators.Store(m)
+}
+
+// RegisterType registers types whose values are compared with ==, like
+// RegisterComparator, instead of field by field. The types must be
+// comparable.
+func RegisterType(types ...reflect.Type) {
+	for _, t := range types {
+		if !t.Comparable() {
+			panic("deep: RegisterType of uncomparable type " + t.String())
+		}
+		RegisterComparator(t, func(a, b interface{}) bool { return a == b })
+	}
 }
 
 type cmp struct {
 	diff        []string
+	diffPaths   []string
+	diffRanks   []int
 	diffM       map[string]DiffResult
-	buff        []string
+	diffN       map[string]interface{}
+	diffG       map[string][]string
+	patch       []PatchOp
+	report      []FieldReport
+	skips       []Skip
+	buff        []pathSeg
+	ctx         []string
 	floatFormat string
 	opts        Options
+	hasDiff     bool
+	start       time.Time
+	nodes       int
+	pathDiffs   []int
+	structs     []reflect.Type
+	typeCounts  map[reflect.Type]int
+	visited     map[visit]bool
+	rootType    reflect.Type
+	common      []string        // path names shared by all diffs for DivergencePoint
+	iface       reflect.Type    // the interface of the values being compared
+	typeChange  string          // how a type change being saved is described
+	typeValues  string          // the values of a type change, if shown
+	leaves      []reflect.Value // the values of a diff being saved with placeholders, like types
+	registered  map[reflect.Type]func(a, b interface{}) bool
+	err         error
+}
+
+// visit is a pair of pointers compared by GraphEqual.
+type visit struct {
+	a, b uintptr
+	typ  reflect.Type
 }
 
-var errorType = reflect.TypeOf((*error)(nil)).Elem()
+// timeoutInterval is how many values are compared between Timeout checks.
+const timeoutInterval = 32
+
+var (
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+	reflectTypeType = reflect.TypeOf((*reflect.Type)(nil)).Elem()
+
+	diffableType        = reflect.TypeOf((*Diffable)(nil)).Elem()
+	runeType            = reflect.TypeOf(rune(0))
+	stringerType        = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	goStringerType      = reflect.TypeOf((*fmt.GoStringer)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	substringType       = reflect.TypeOf(Substring(""))
+	binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	readerType          = reflect.TypeOf((*io.Reader)(nil)).Elem()
+
+	// syncPrimitiveTypes are skipped by SkipSyncPrimitives.
+	syncPrimitiveTypes = map[reflect.Type]bool{
+		reflect.TypeOf(sync.Mutex{}):     true,
+		reflect.TypeOf(sync.RWMutex{}):   true,
+		reflect.TypeOf(sync.Once{}):      true,
+		reflect.TypeOf(sync.WaitGroup{}): true,
+	}
+)
 
 // Equal compares variables a and b, recursing into their structure up to
 // MaxDepth levels deep, and returns a list of differences, or nil if there are
@@ -70,12 +629,7 @@ var errorType = reflect.TypeOf((*error)(nil)).Elem()
 // equality.
 
 func CompareM(a, b interface{}, opts ...Options) (map[string]DiffResult, bool) {
-	var o Options
-	if len(opts) > 0 {
-		o = opts[0]
-	} else {
-		o = DefaultOptions
-	}
+	o := optsOrDefault(opts)
 	o.asMap = true
 	if c, hasDiff := compare(a, b, o); hasDiff {
 		return c.diffM, hasDiff
@@ -84,76 +638,498 @@ func CompareM(a, b interface{}, opts ...Options) (map[string]DiffResult, bool) {
 }
 
 func CompareS(a, b interface{}, opts ...Options) ([]string, bool) {
-	var o Options
-	if len(opts) > 0 {
-		o = opts[0]
-	} else {
-		o = DefaultOptions
+	if c, hasDiff := compare(a, b, optsOrDefault(opts)); hasDiff {
+		return c.diff, hasDiff
 	}
+	return nil, false
+}
+
+// FirstDiff returns the path and values of the first difference between a and
+// b, like CompareM, and stops comparing once it's found. found is false if a
+// and b are equal.
+func FirstDiff(a, b interface{}, opts ...Options) (path string, old, new interface{}, found bool) {
+	o := optsOrDefault(opts)
+	o.asMap = true
+	o.firstOnly = true
+	c, hasDiff := compare(a, b, o)
+	for _, d := range c.diffM {
+		return d.Path, d.OldValue, d.NewValue, true
+	}
+	return "", nil, nil, hasDiff
+}
+
+// CompareTypeCounts returns the number of differences in the fields of each
+// struct type, like 3 for User and 1 for Address, counted for the innermost
+// struct that contains them. Differences not in a struct aren't counted.
+func CompareTypeCounts(a, b interface{}, opts ...Options) (map[reflect.Type]int, bool) {
+	o := optsOrDefault(opts)
+	o.asCounts = true
+	if c, hasDiff := compare(a, b, o); hasDiff {
+		return c.typeCounts, hasDiff
+	}
+	return nil, false
+}
+
+// CompareMNested is like CompareM but returns nested maps that mirror the
+// shape of the compared values instead of flat paths. For example, a diff at
+// "Tags.env" is returned as {"Tags": {"env": DiffResult}}.
+func CompareMNested(a, b interface{}, opts ...Options) (map[string]interface{}, bool) {
+	o := optsOrDefault(opts)
+	o.asNested = true
+	if c, hasDiff := compare(a, b, o); hasDiff {
+		return c.diffN, hasDiff
+	}
+	return nil, false
+}
+
+// ComparePatch returns the operations that transform a into b, or nil if
+// there are none. Map keys and slice elements only in b are added, those only
+// in a are removed, and other differences are replaced.
+func ComparePatch(a, b interface{}, opts ...Options) ([]PatchOp, bool) {
+	o := optsOrDefault(opts)
+	o.asPatch = true
+	if c, hasDiff := compare(a, b, o); hasDiff {
+		return c.patch, hasDiff
+	}
+	return nil, false
+}
+
+// CompareGrouped is like CompareS but groups the differences by the first
+// name in their path, like the top-level struct field. A difference between
+// the compared values themselves is grouped by "".
+func CompareGrouped(a, b interface{}, opts ...Options) (map[string][]string, bool) {
+	o := optsOrDefault(opts)
+	o.asGrouped = true
+	if c, hasDiff := compare(a, b, o); hasDiff {
+		return c.diffG, hasDiff
+	}
+	return nil, false
+}
+
+// CompareTrace is like CompareS but also returns the values that were not
+// compared, and why, if TraceSkips is true.
+func CompareTrace(a, b interface{}, opts ...Options) ([]string, []Skip) {
+	c, hasDiff := compare(a, b, optsOrDefault(opts))
+	if !hasDiff {
+		return nil, c.skips
+	}
+	return c.diff, c.skips
+}
+
+// CompareSchema is like CompareS but only compares the types of a and b, like
+// a schema and a value that must conform to it. Type mismatches, like an int
+// field in an interface{} that is a string in b, are differences; different
+// values of the same type, missing map keys, nil pointers in a or b, and extra
+// slice elements are not.
+func CompareSchema(a, b interface{}, opts ...Options) ([]string, bool) {
+	o := optsOrDefault(opts)
+	o.asSchema = true
 	if c, hasDiff := compare(a, b, o); hasDiff {
 		return c.diff, hasDiff
 	}
 	return nil, false
 }
 
-func compare(a, b interface{}, opts Options) (c *cmp, hasDiff bool) {
-	aVal := reflect.ValueOf(a)
-	bVal := reflect.ValueOf(b)
-	c = &cmp{
+// Report returns every compared leaf value, like a string field or a value
+// with an Equal method, whether equal or not, in the order compared. Missing
+// values, like a map key only in a, are reported as not equal.
+func Report(a, b interface{}, opts ...Options) []FieldReport {
+	o := optsOrDefault(opts)
+	o.asReport = true
+	c, _ := compare(a, b, o)
+	return c.report
+}
+
+// Similarity returns the ratio, from 0 to 1, of equal leaf values, like those
+// returned by Report, to all compared leaf values. Identical values return 1,
+// as do values with no leaves, like two nil values.
+func Similarity(a, b interface{}, opts ...Options) float64 {
+	report := Report(a, b, opts...)
+	if len(report) == 0 {
+		return 1
+	}
+	equal := 0
+	for _, r := range report {
+		if r.Equal {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(report))
+}
+
+// DivergencePoint returns the deepest path, like "User.Address", under which
+// all differences between a and b are, or an empty string if a and b are
+// equal or differ in more than one top-level field, key, or element. The path
+// is Dotted, joined with the PathSeparator. MaxDiff is ignored so every
+// difference is found.
+func DivergencePoint(a, b interface{}, opts ...Options) string {
+	o := optsOrDefault(opts)
+	o.MaxDiff = 0
+	o.asDivergence = true
+	c, _ := compare(a, b, o)
+	return strings.Join(c.common, c.pathSeparator())
+}
+
+// CompareJSONValues is like CompareS but tuned for values decoded from JSON,
+// like by json.Unmarshal into an interface{}, which decodes every number as
+// a float64 and an empty array as an empty, not nil, slice. Numbers of
+// different types are compared by value, and nil slices equal empty slices.
+func CompareJSONValues(a, b interface{}, opts ...Options) ([]string, bool) {
+	o := optsOrDefault(opts)
+	o.NumericKindInsensitive = true
+	o.NilSliceEqualsEmpty = true
+	return CompareS(a, b, o)
+}
+
+// CompareJSONToStruct is like CompareS but a is jsonBytes decoded by
+// json.Unmarshal into a new value of the type of v, like a struct or a pointer
+// to one, and b is v. It returns the json.Unmarshal error, and no diffs, if
+// jsonBytes cannot be decoded.
+func CompareJSONToStruct(jsonBytes []byte, v interface{}, opts ...Options) ([]string, bool, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, false, errors.New("deep: CompareJSONToStruct of nil")
+	}
+	isPtr := t.Kind() == reflect.Ptr
+	if isPtr {
+		t = t.Elem()
+	}
+	decoded := reflect.New(t)
+	if err := json.Unmarshal(jsonBytes, decoded.Interface()); err != nil {
+		return nil, false, err
+	}
+	if !isPtr {
+		decoded = decoded.Elem()
+	}
+	diff, hasDiff := CompareS(decoded.Interface(), v, opts...)
+	return diff, hasDiff, nil
+}
+
+// CompareYAMLValues is like CompareS but tuned for values decoded from YAML,
+// like by gopkg.in/yaml.v2 into an interface{}, which decodes mappings as
+// map[interface{}]interface{} with keys of any type, and numbers as int,
+// uint64, or float64 depending on their size and syntax. Numbers of different
+// types are compared by value, nil slices equal empty slices, and map keys
+// are compared in sorted order so the diffs are stable.
+func CompareYAMLValues(a, b interface{}, opts ...Options) ([]string, bool) {
+	o := optsOrDefault(opts)
+	o.NumericKindInsensitive = true
+	o.NilSliceEqualsEmpty = true
+	o.DeterministicMapOrder = true
+	return CompareS(a, b, o)
+}
+
+// CompareBool returns true if a and b differ. It's faster than CompareS when
+// the differences are not needed because it stops at the first difference
+// and doesn't format it.
+func CompareBool(a, b interface{}, opts ...Options) bool {
+	o := optsOrDefault(opts)
+	o.boolOnly = true
+	_, hasDiff := compare(a, b, o)
+	return hasDiff
+}
+
+// CompareE is like CompareS but returns an error wrapping ErrPanic instead of
+// panicking if reflection on an exotic value, like an unexported field that
+// cannot be called, fails. The differences are nil if there is a panic. If
+// the Timeout is exceeded, the differences found so far and ErrTimeout are
+// returned.
+func CompareE(a, b interface{}, opts ...Options) (diff []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			diff = nil
+			err = fmt.Errorf("%w: %v", ErrPanic, r)
+		}
+	}()
+	c, hasDiff := compare(a, b, optsOrDefault(opts))
+	if !hasDiff {
+		return nil, c.err
+	}
+	return c.diff, c.err
+}
+
+func optsOrDefault(opts []Options) Options {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return DefaultOptions
+}
+
+// CompareValues is like CompareS but compares reflect.Values, which can be
+// invalid, like the zero reflect.Value. Two invalid values are equal.
+func CompareValues(a, b reflect.Value, opts ...Options) ([]string, bool) {
+	c := newCmp(optsOrDefault(opts))
+	c.equals(a, b, 0)
+	if c.hasDiff {
+		return c.diff, true
+	}
+	return nil, false
+}
+
+func newCmp(opts Options) *cmp {
+	return &cmp{
 		diff:        []string{},
 		diffM:       make(map[string]DiffResult),
-		buff:        []string{},
+		diffN:       make(map[string]interface{}),
+		diffG:       make(map[string][]string),
+		typeCounts:  make(map[reflect.Type]int),
+		buff:        []pathSeg{},
 		opts:        opts,
 		floatFormat: fmt.Sprintf("%%.%df", opts.FloatPrecision),
+		start:       time.Now(),
+		registered:  registeredComparators(),
 	}
+}
+
+func registeredComparators() map[reflect.Type]func(a, b interface{}) bool {
+	m, _ := comparators.Load().(map[reflect.Type]func(a, b interface{}) bool)
+	return m
+}
+
+func compare(a, b interface{}, opts Options) (c *cmp, hasDiff bool) {
+	aVal := reflect.ValueOf(a)
+	bVal := reflect.ValueOf(b)
+	c = newCmp(opts)
 
 	if a == nil && b == nil {
 		return
+	} else if opts.TypedNilEqualsNil && (a == nil && isNilPtr(bVal) || b == nil && isNilPtr(aVal)) {
+		return
 	} else if a == nil && b != nil {
-		c.saveDiff(b, "<nil pointer>")
+		c.saveLeafDiff(reflect.TypeOf(b), nilPointer, b, reflect.Value{}, bVal)
 	} else if a != nil && b == nil {
-		c.saveDiff(a, "<nil pointer>")
+		c.saveLeafDiff(reflect.TypeOf(a), a, nilPointer, aVal, reflect.Value{})
 	}
-	if len(c.diff) > 0 {
+	if c.hasDiff {
 		return c, true
 	}
 
 	c.equals(aVal, bVal, 0)
-	if len(c.diff) > 0 || len(c.diffM) > 0 {
-		return c, true
+	if c.opts.SortOutput {
+		c.sortOutput()
+	}
+	if c.opts.DiffRank != nil {
+		sort.Stable(diffsByRank{diffsByPath{c.diff, c.diffPaths, c.diffRanks}})
+	}
+	return c, c.hasDiff
+}
+
+// sortOutput sorts the diffs, patch operations, and reports by path.
+func (c *cmp) sortOutput() {
+	sort.Stable(diffsByPath{c.diff, c.diffPaths, c.diffRanks})
+	sort.SliceStable(c.patch, func(i, j int) bool {
+		return c.patch[i].Path < c.patch[j].Path
+	})
+	sort.SliceStable(c.report, func(i, j int) bool {
+		return c.report[i].Path < c.report[j].Path
+	})
+}
+
+// diffsByPath sorts diffs by their paths. The ranks, if any, are sorted with
+// the diffs.
+type diffsByPath struct {
+	diffs []string
+	paths []string
+	ranks []int
+}
+
+func (d diffsByPath) Len() int           { return len(d.diffs) }
+func (d diffsByPath) Less(i, j int) bool { return d.paths[i] < d.paths[j] }
+func (d diffsByPath) Swap(i, j int) {
+	d.diffs[i], d.diffs[j] = d.diffs[j], d.diffs[i]
+	d.paths[i], d.paths[j] = d.paths[j], d.paths[i]
+	if len(d.ranks) > 0 {
+		d.ranks[i], d.ranks[j] = d.ranks[j], d.ranks[i]
 	}
-	return
 }
 
+// diffsByRank sorts diffs by their DiffRank ranks, highest first.
+type diffsByRank struct {
+	diffsByPath
+}
+
+func (d diffsByRank) Less(i, j int) bool { return d.ranks[i] > d.ranks[j] }
+
 func (c *cmp) equals(a, b reflect.Value, level int) {
+	iface := c.iface
+	c.iface = nil
+
 	if level > c.opts.MaxDepth {
 		c.logError(ErrMaxRecursion)
 		return
 	}
 
-	// Check if one value is nil, e.g. T{x: *X} and T.x is nil
+	if c.opts.Timeout > 0 {
+		if c.nodes++; c.nodes%timeoutInterval == 0 && time.Since(c.start) > c.opts.Timeout {
+			c.err = ErrTimeout
+			c.logError(ErrTimeout)
+		}
+		if c.err != nil {
+			return
+		}
+	}
+
+	// Check if either value is reflect.Invalid, e.g. T{x: *X} and T.x is nil.
+	// Two invalid values are equal; invalid and valid values are not.
 	if !a.IsValid() || !b.IsValid() {
-		if a.IsValid() && !b.IsValid() {
-			c.saveDiff(a.Type(), "<nil pointer>")
-		} else if !a.IsValid() && b.IsValid() {
-			c.saveDiff("<nil pointer>", b.Type())
+		switch {
+		case !a.IsValid() && !b.IsValid():
+			c.saveEqual(a, b)
+		case c.opts.NilPointerEqualsZero && (a.IsValid() && a.IsZero() || b.IsValid() && b.IsZero()):
+			c.saveEqual(a, b)
+		case c.opts.ZeroTimeEqualsNil && (isZeroTime(a) || isZeroTime(b)):
+			c.saveEqual(a, b)
+		case c.opts.LeafDiffsOnly && a.IsValid():
+			c.equals(a, reflect.Zero(a.Type()), level)
+		case c.opts.LeafDiffsOnly:
+			c.equals(reflect.Zero(b.Type()), b, level)
+		case a.IsValid():
+			c.saveLeafDiff(a.Type(), a.Type(), nilPointer, a, b)
+		default:
+			c.saveLeafDiff(b.Type(), nilPointer, b.Type(), a, b)
 		}
 		return
 	}
 
-	// If differenet types, they can't be equal
+	if c.opts.IgnoreValue != nil && a.CanInterface() && b.CanInterface() &&
+		c.opts.IgnoreValue(c.names(), a.Interface(), b.Interface()) {
+		c.saveSkip("", "ignored")
+		return
+	}
+
 	aType := a.Type()
 	bType := b.Type()
+
+	if c.opts.asMap || c.opts.asNested {
+		c.recordType(aType)
+	}
+
+	// Byte arrays encoding numbers can be compared even if their lengths,
+	// and so their types, differ.
+	if c.opts.BytesAsNumber && isByteArray(aType) && isByteArray(bType) {
+		aNum := new(big.Int).SetBytes(arrayBytes(a))
+		bNum := new(big.Int).SetBytes(arrayBytes(b))
+		if aNum.Cmp(bNum) != 0 {
+			c.saveDiff(aType, aNum, bNum)
+		} else {
+			c.saveEqual(a, b)
+		}
+		return
+	}
+
+	if c.opts.EnumByName && isEnum(a) && isEnum(b) {
+		aName := a.Interface().(fmt.Stringer).String()
+		bName := b.Interface().(fmt.Stringer).String()
+		if aName != bName {
+			c.saveDiff(aType, aName, bName)
+		} else {
+			c.saveEqual(a, b)
+		}
+		return
+	}
+
+	// An expected substring, like Contains("foo"), matches strings that
+	// contain it.
+	if bType == substringType && aType.Kind() == reflect.String && aType != substringType {
+		if !strings.Contains(a.String(), b.String()) {
+			c.saveDiff(aType, a.String(), b.Interface())
+		} else {
+			c.saveEqual(a, b)
+		}
+		return
+	}
+
+	// If differenet types, they can't be equal
 	if aType != bType {
-		c.saveDiff(aType, bType)
+		if c.opts.ByFieldName && aType.Kind() == reflect.Struct && bType.Kind() == reflect.Struct {
+			c.equalsByFieldName(a, b, level)
+			return
+		}
+		if c.opts.NumericKindInsensitive && isNumber(aType.Kind()) && isNumber(bType.Kind()) {
+			if !c.numbersEqual(a, b) {
+				c.saveDiff(aType, numberValue(a), numberValue(b))
+			} else {
+				c.saveEqual(a, b)
+			}
+			return
+		}
+		if c.opts.AutoDerefMixed {
+			if aType.Kind() == reflect.Ptr && aType.Elem() == bType {
+				c.equals(a.Elem(), b, level+1)
+				return
+			}
+			if bType.Kind() == reflect.Ptr && bType.Elem() == aType {
+				c.equals(a, b.Elem(), level+1)
+				return
+			}
+			if aType.Kind() == reflect.Map && bType.Kind() == reflect.Map &&
+				aType.Key() == bType.Key() && isMixedPtr(aType.Elem(), bType.Elem()) {
+				c.equalsMap(a, b, level)
+				return
+			}
+		}
+		if c.opts.ArraySliceInterchangeable && isArrayOrSlice(aType) && isArrayOrSlice(bType) && aType.Elem() == bType.Elem() {
+			c.equalsPositional(a, b, level)
+			return
+		}
+		if c.opts.SoftTypeMismatch && c.equalsSoft(a, b) {
+			return
+		}
+		for _, iface := range c.opts.CompareAsInterface {
+			if aType.Implements(iface) && bType.Implements(iface) && c.equalsAsInterface(a, b, iface, level) {
+				return
+			}
+		}
+		if c.opts.DumpOnMismatch && len(c.buff) == 0 && a.CanInterface() && b.CanInterface() {
+			c.saveDiff(aType, c.dump(a), c.dump(b))
+		} else if iface != nil {
+			c.saveTypeChange(iface, a, b)
+		} else {
+			c.saveLeafDiff(aType, aType, bType, a, b)
+		}
 		c.logError(ErrTypeMismatch)
 		return
 	}
 
+	if len(c.opts.Redact) > 0 && c.pathIn(c.opts.Redact) && !nilMismatch(a, b) {
+		c.saveSkip("", "redacted")
+		return
+	}
+
+	if fn, ok := c.comparator(aType); ok && a.CanInterface() && b.CanInterface() {
+		if fn(a.Interface(), b.Interface()) {
+			c.saveEqual(a, b)
+		} else {
+			c.saveDiff(aType, a.Interface(), b.Interface())
+		}
+		return
+	}
+
+	// Compare the canonical forms of values with a canonicalizer. If the
+	// canonical form is another type, compare it like any other value.
+	// Otherwise, continue with the canonical values in place so they are not
+	// canonicalized again.
+	if canon, ok := c.opts.Canonicalizers[aType]; ok && a.CanInterface() && b.CanInterface() {
+		a = reflect.ValueOf(canon(a.Interface()))
+		b = reflect.ValueOf(canon(b.Interface()))
+		if !a.IsValid() || !b.IsValid() || a.Type() != aType || b.Type() != bType {
+			c.equals(a, b, level)
+			return
+		}
+	}
+
 	// Primitive https://golang.org/pkg/reflect/#Kind
 	aKind := a.Kind()
 	bKind := b.Kind()
 
+	// An interface holding a nil pointer isn't nil, but it can be equal to
+	// one that is.
+	if c.opts.TypedNilEqualsNil && aKind == reflect.Interface && bKind == reflect.Interface &&
+		(a.IsNil() || isNilPtr(a.Elem())) && (b.IsNil() || isNilPtr(b.Elem())) {
+		c.saveEqual(a, b)
+		return
+	}
+
 	// If both types implement the error interface, compare the error strings.
 	// This must be done before dereferencing because the interface is on a
 	// pointer receiver.
@@ -162,16 +1138,72 @@ func (c *cmp) equals(a, b reflect.Value, level int) {
 			aString := a.MethodByName("Error").Call(nil)[0].String()
 			bString := b.MethodByName("Error").Call(nil)[0].String()
 			if aString != bString {
-				c.saveDiff(aString, bString)
+				c.saveDiff(aType, aString, bString)
+			} else {
+				c.saveEqual(a, b)
+			}
+			return
+		}
+	}
+
+	// If both values are reflect.Type, like reflect.TypeOf(1), compare the
+	// type names. Their implementation is unexported, so comparing it would
+	// find no differences.
+	if (aKind == reflect.Ptr || aKind == reflect.Interface) && aKind == bKind &&
+		aType.Implements(reflectTypeType) && bType.Implements(reflectTypeType) {
+		switch {
+		case a.IsNil() && b.IsNil():
+			// equal, below
+		case a.IsNil():
+			c.saveLeafDiff(aType, nilPointer, b.MethodByName("String").Call(nil)[0].String(), a, b)
+			return
+		case b.IsNil():
+			c.saveLeafDiff(aType, a.MethodByName("String").Call(nil)[0].String(), nilPointer, a, b)
+			return
+		default:
+			aString := a.MethodByName("String").Call(nil)[0].String()
+			bString := b.MethodByName("String").Call(nil)[0].String()
+			if aString != bString {
+				c.saveDiff(aType, aString, bString)
+			} else {
+				c.saveEqual(a, b)
 			}
 			return
 		}
 	}
 
+	if c.opts.UseDiffable && aType.Implements(diffableType) && c.equalsDiffable(a, b) {
+		return
+	}
+
+	if c.opts.CompareBinaryMarshaler && aType.Implements(binaryMarshalerType) && c.equalsBinary(a, b) {
+		return
+	}
+
+	if c.opts.CompareReaderContent && c.opts.ReaderTypes[aType] && aType.Implements(readerType) && c.equalsReader(a, b) {
+		return
+	}
+
+	if c.opts.CompareViaGetters && c.opts.GetterTypes[aType] && c.equalsGetters(a, b, level) {
+		return
+	}
+
+	if c.opts.CompareAtomics && aKind == reflect.Struct && aType.PkgPath() == "sync/atomic" && c.equalsAtomic(a, b, level) {
+		return
+	}
+
+	if c.opts.PreferGoString && aType.Implements(goStringerType) && c.equalsGoString(a, b, level) {
+		return
+	}
+
 	// Dereference pointers and interface{}
 	if aElem, bElem := (aKind == reflect.Ptr || aKind == reflect.Interface),
 		(bKind == reflect.Ptr || bKind == reflect.Interface); aElem || bElem {
 
+		if c.opts.GraphEqual && aKind == reflect.Ptr && bKind == reflect.Ptr && c.seen(a, b) {
+			return
+		}
+
 		if aElem {
 			a = a.Elem()
 		}
@@ -180,10 +1212,28 @@ func (c *cmp) equals(a, b reflect.Value, level int) {
 			b = b.Elem()
 		}
 
+		// Consecutive pointers, like ***int, count as one level so pointer
+		// chains don't exhaust MaxDepth. Each pointer is still checked for
+		// nil because it's compared at the same level.
+		if aKind == reflect.Ptr && bKind == reflect.Ptr && isPtrChain(aType) {
+			c.equals(a, b, level)
+			return
+		}
+
+		// Interfaces holding values of different types are reported as a
+		// type change at the interface.
+		if aKind == reflect.Interface && bKind == reflect.Interface {
+			c.iface = aType
+		}
 		c.equals(a, b, level+1)
 		return
 	}
 
+	// Values of the same primitive type conform to the schema.
+	if c.opts.asSchema && aKind != reflect.Struct && aKind != reflect.Map && aKind != reflect.Array && aKind != reflect.Slice {
+		return
+	}
+
 	switch aKind {
 
 	/////////////////////////////////////////////////////////////////////
@@ -202,6 +1252,17 @@ func (c *cmp) equals(a, b reflect.Value, level int) {
 			Iterate through the fields (FirstName, LastName), recurse into their values.
 		*/
 
+		if c.opts.TimeTruncate > 0 && aType == timeType && a.CanInterface() && b.CanInterface() {
+			aTime := a.Interface().(time.Time).Truncate(c.opts.TimeTruncate)
+			bTime := b.Interface().(time.Time).Truncate(c.opts.TimeTruncate)
+			if !aTime.Equal(bTime) {
+				c.saveDiff(aType, a, b)
+			} else {
+				c.saveEqual(a, b)
+			}
+			return
+		}
+
 		// Types with an Equal() method, like time.Time, only if struct field
 		// is exported (CanInterface)
 		if eqFunc := a.MethodByName("Equal"); eqFunc.IsValid() && eqFunc.CanInterface() {
@@ -218,19 +1279,42 @@ func (c *cmp) equals(a, b reflect.Value, level int) {
 			if funcType.NumIn() == 1 && funcType.In(0) == bType {
 				retVals := eqFunc.Call([]reflect.Value{b})
 				if !retVals[0].Bool() {
-					c.saveDiff(a, b)
+					c.saveDiff(aType, a, b)
+				} else {
+					c.saveEqual(a, b)
 				}
 				return
 			}
 		}
 
-		for i := 0; i < a.NumField(); i++ {
-			if aType.Field(i).PkgPath != "" && !c.opts.CompareUnexportedFields {
+		if c.opts.UnwrapSingleField && aType.NumField() == 1 && c.unwrappable(aType.Field(0)) {
+			c.equals(a.Field(0), b.Field(0), level+1)
+			return
+		}
+
+		c.pushContext(a)
+		defer c.popContext()
+		c.pushStruct(aType)
+		defer c.popStruct()
+
+		for i := 0; i < a.NumField(); i++ {
+			if aType.Field(i).PkgPath != "" && !c.opts.CompareUnexportedFields {
+				c.saveSkip(aType.Field(i).Name, "unexported")
 				continue // skip unexported field, e.g. s in type T struct {s string}
 			}
 
+			if c.opts.SkipSyncPrimitives && syncPrimitiveTypes[aType.Field(i).Type] {
+				c.saveSkip(aType.Field(i).Name, "sync primitive")
+				continue
+			}
+
 			tagOpts := getTagOpts(aType.Field(i).Tag.Get("compare"))
-			if tagOpts.skip {
+			if tagOpts.skip || c.ignoreTagged(aType.Field(i)) {
+				c.saveSkip(aType.Field(i).Name, "tag")
+				continue
+			}
+			if c.ignoreField(aType.Field(i)) {
+				c.saveSkip(aType.Field(i).Name, "ignored")
 				continue
 			}
 
@@ -246,12 +1330,17 @@ func (c *cmp) equals(a, b reflect.Value, level int) {
 			af := a.Field(i)
 			bf := b.Field(i)
 
-			// Recurse to compare the field values
-			c.equals(af, bf, level+1)
+			// Recurse to compare the field values, unless the field is
+			// shallow, like `compare:",shallow"`
+			if tagOpts.shallow && af.CanInterface() && bf.CanInterface() {
+				c.equalsShallow(af, bf)
+			} else {
+				c.equals(af, bf, level+1)
+			}
 
 			c.pop() // pop field name from buff
 
-			if len(c.diff) >= c.opts.MaxDiff {
+			if c.done() {
 				break
 			}
 		}
@@ -271,121 +1360,162 @@ func (c *cmp) equals(a, b reflect.Value, level int) {
 			Iterate through the map keys (foo, bar), recurse into their values.
 		*/
 
-		if a.IsNil() || b.IsNil() {
+		c.equalsMap(a, b, level)
+	case reflect.Array:
+		n := c.maxElems(a.Len())
+		for i := 0; i < n; i++ {
+			c.pushIndex(i)
+			c.equals(a.Index(i), b.Index(i), level+1)
+			c.pop()
+			if c.done() {
+				break
+			}
+		}
+	case reflect.Slice:
+		if (a.IsNil() || b.IsNil()) && !c.opts.LeafDiffsOnly {
+			if c.opts.NilSliceEqualsEmpty && a.Len() == 0 && b.Len() == 0 {
+				return
+			}
 			if a.IsNil() && !b.IsNil() {
-				c.saveDiff("[empty value]", b.Interface())
+				c.saveDiff(aType, emptyValue, b)
 			} else if !a.IsNil() && b.IsNil() {
-				c.saveDiff(a.Interface(), "[empty value]")
+				c.saveDiff(aType, a, emptyValue)
 			}
 			return
 		}
 
-		if a.Pointer() == b.Pointer() {
+		if c.opts.CompareSliceCapacity && a.Cap() != b.Cap() {
+			c.saveDiff(aType, fmt.Sprintf("cap=%d", a.Cap()), fmt.Sprintf("cap=%d", b.Cap()))
+			if c.done() {
+				return
+			}
+		}
+
+		if a.Pointer() == b.Pointer() && !c.opts.asReport {
 			return
 		}
 
-		for _, key := range a.MapKeys() {
-			c.push(fmt.Sprintf("%s", key))
+		if c.opts.FastByteSlices && aType.Elem().Kind() == reflect.Uint8 {
+			c.equalsBytes(a, b)
+			return
+		}
 
-			aVal := a.MapIndex(key)
-			bVal := b.MapIndex(key)
-			if bVal.IsValid() {
-				c.equals(aVal, bVal, level+1)
+		if c.opts.RunesAsString && aType.Elem() == runeType {
+			aString := runesString(a)
+			bString := runesString(b)
+			if aString != bString {
+				c.saveDiff(aType, aString, bString)
 			} else {
-				c.saveDiff(aVal.Interface(), "[empty value]")
-			}
-
-			c.pop()
-
-			if len(c.diff) >= c.opts.MaxDiff {
-				return
+				c.saveEqual(a, b)
 			}
+			return
 		}
 
-		for _, key := range b.MapKeys() {
-			if aVal := a.MapIndex(key); aVal.IsValid() {
-				continue
-			}
+		if key, ok := sliceKeyField(aType.Elem()); ok && c.equalsByKey(a, b, key, level) {
+			return
+		}
 
-			c.push(key.String())
-			c.saveDiff("[empty value]", b.MapIndex(key).Interface())
-			c.pop()
-			if len(c.diff) >= c.opts.MaxDiff {
+		if c.opts.SortSlicesBeforeCompare {
+			if less := c.sliceLess(aType.Elem()); less != nil {
+				c.equalsSorted(a, b, less, level)
 				return
 			}
 		}
-	case reflect.Array:
-		n := a.Len()
-		for i := 0; i < n; i++ {
-			c.push(fmt.Sprintf("#%d", i))
-			c.equals(a.Index(i), b.Index(i), level+1)
-			c.pop()
-			if len(c.diff) >= c.opts.MaxDiff {
-				break
-			}
-		}
-	case reflect.Slice:
-		if a.IsNil() || b.IsNil() {
-			if a.IsNil() && !b.IsNil() {
-				c.saveDiff("[empty value]", b)
-			} else if !a.IsNil() && b.IsNil() {
-				c.saveDiff(a, "[empty value]")
-			}
+
+		if len(c.opts.OrderIndependentPaths) > 0 && c.pathIn(c.opts.OrderIndependentPaths) {
+			c.equalsUnordered(a, b, level)
 			return
 		}
 
-		if a.Pointer() == b.Pointer() {
+		if c.opts.SliceLCS {
+			c.equalsLCS(a, b, level)
 			return
 		}
 
-		aLen := a.Len()
-		bLen := b.Len()
-		n := aLen
-		if bLen > aLen {
-			n = bLen
-		}
-		for i := 0; i < n; i++ {
-			c.push(fmt.Sprintf("#%d", i))
-			if i < aLen && i < bLen {
-				c.equals(a.Index(i), b.Index(i), level+1)
-			} else if i < aLen {
-				c.saveDiff(a.Index(i), "[empty value]")
-			} else {
-				c.saveDiff("[empty value]", b.Index(i))
-			}
-			c.pop()
-			if len(c.diff) >= c.opts.MaxDiff {
-				break
-			}
-		}
+		c.equalsPositional(a, b, level)
 
 	/////////////////////////////////////////////////////////////////////
 	// Primitive kinds
 	/////////////////////////////////////////////////////////////////////
 
 	case reflect.Float32, reflect.Float64:
+		if c.opts.FloatExact {
+			if !floatsIdentical(a.Float(), b.Float()) {
+				c.saveDiff(aType, a.Float(), b.Float())
+			} else {
+				c.saveEqual(a, b)
+			}
+			return
+		}
 		// Avoid 0.04147685731961082 != 0.041476857319611
 		// 6 decimal places is close enough
 		aval := fmt.Sprintf(c.floatFormat, a.Float())
 		bval := fmt.Sprintf(c.floatFormat, b.Float())
-		if aval != bval {
-			c.saveDiff(a.Float(), b.Float())
+		if aval != bval && !c.belowNoiseFloor(a.Float(), b.Float()) {
+			c.saveDiff(aType, a.Float(), b.Float())
+		} else {
+			c.saveEqual(a, b)
 		}
 	case reflect.Bool:
 		if a.Bool() != b.Bool() {
-			c.saveDiff(a.Bool(), b.Bool())
+			c.saveDiff(aType, a.Bool(), b.Bool())
+		} else {
+			c.saveEqual(a, b)
 		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		if a.Int() != b.Int() {
-			c.saveDiff(a.Int(), b.Int())
+		if c.opts.DurationTolerance > 0 && aType == durationType {
+			aDur := time.Duration(a.Int())
+			bDur := time.Duration(b.Int())
+			if delta := aDur - bDur; delta > c.opts.DurationTolerance || -delta > c.opts.DurationTolerance {
+				c.saveDiff(aType, aDur, bDur)
+			} else {
+				c.saveEqual(a, b)
+			}
+			return
+		}
+		if a.Int() != b.Int() && !c.belowNoiseFloor(float64(a.Int()), float64(b.Int())) {
+			if names, ok := c.opts.EnumNames[aType]; ok {
+				c.saveDiff(aType, enumName(names, a.Int()), enumName(names, b.Int()))
+				return
+			}
+			c.saveDiff(aType, a.Int(), b.Int())
+		} else {
+			c.saveEqual(a, b)
 		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		if a.Uint() != b.Uint() {
-			c.saveDiff(a.Uint(), b.Uint())
+		if a.Uint() != b.Uint() && !c.belowNoiseFloor(float64(a.Uint()), float64(b.Uint())) {
+			if names, ok := c.opts.EnumNames[aType]; ok {
+				c.saveDiff(aType, enumName(names, int64(a.Uint())), enumName(names, int64(b.Uint())))
+				return
+			}
+			c.saveDiff(aType, a.Uint(), b.Uint())
+		} else {
+			c.saveEqual(a, b)
 		}
 	case reflect.String:
-		if a.String() != b.String() {
-			c.saveDiff(a.String(), b.String())
+		if !c.stringsEqual(a.String(), b.String()) {
+			c.saveDiff(aType, a.String(), b.String())
+		} else {
+			c.saveEqual(a, b)
+		}
+
+	case reflect.Func:
+		/*
+			Funcs are equal if both are nil or both point to the same code,
+			like the same top-level func. Closures of the same func literal
+			are equal even if they capture different variables.
+		*/
+		switch {
+		case a.IsNil() && b.IsNil():
+			c.saveEqual(a, b)
+		case a.IsNil():
+			c.saveDiff(aType, nilFunc, funcValue)
+		case b.IsNil():
+			c.saveDiff(aType, funcValue, nilFunc)
+		case a.Pointer() != b.Pointer():
+			c.saveDiff(aType, funcName(a), funcName(b))
+		default:
+			c.saveEqual(a, b)
 		}
 
 	default:
@@ -393,60 +1523,1892 @@ func (c *cmp) equals(a, b reflect.Value, level int) {
 	}
 }
 
-func (c *cmp) push(name string) {
-	c.buff = append(c.buff, name)
+// funcName returns the name of non-nil func v without its package path, like
+// "strings.ToUpper".
+func funcName(v reflect.Value) string {
+	f := runtime.FuncForPC(v.Pointer())
+	if f == nil {
+		return funcValue
+	}
+	return pkgPathPrefix.ReplaceAllString(f.Name(), "")
 }
 
-func (c *cmp) pop() {
-	if len(c.buff) > 0 {
-		c.buff = c.buff[0 : len(c.buff)-1]
+// pathIn returns true if the current Dotted path is in paths.
+func (c *cmp) pathIn(paths []string) bool {
+	path := c.dottedPath()
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// isZero returns true if v, or the value in interface v, is the zero value.
+func isZero(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return !v.IsValid() || v.IsZero()
+}
+
+// nilMismatch returns true if a and b are nillable and only one is nil.
+func nilMismatch(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return a.IsNil() != b.IsNil()
+	}
+	return false
+}
+
+// done returns true if no more differences are needed: the comparison timed
+// out, MaxDiff or MaxDiffPerPath for the current container is reached, or any
+// difference is found when only a bool result is needed.
+func (c *cmp) done() bool {
+	if c.err != nil {
+		return true
 	}
+	if c.opts.boolOnly || c.opts.firstOnly {
+		return c.hasDiff
+	}
+	if c.opts.asDivergence && len(c.diff) > 0 && len(c.common) == 0 {
+		return true // diverged at the root
+	}
+	if c.opts.MaxDiffPerPath > 0 && len(c.buff) < len(c.pathDiffs) && c.pathDiffs[len(c.buff)] >= c.opts.MaxDiffPerPath {
+		return true
+	}
+	return c.opts.MaxDiff > 0 && len(c.diff) >= c.opts.MaxDiff
+}
+
+// countPathDiff counts a difference for MaxDiffPerPath toward the container
+// of the current value. pathDiffs[n] is the count for the container at path
+// length n.
+func (c *cmp) countPathDiff() {
+	n := len(c.buff) - 1
+	if c.opts.MaxDiffPerPath <= 0 || n < 0 {
+		return
+	}
+	for len(c.pathDiffs) <= n {
+		c.pathDiffs = append(c.pathDiffs, 0)
+	}
+	c.pathDiffs[n]++
 }
 
-func (c *cmp) saveDiff(aval, bval interface{}) {
+// canCallMethods returns true if the methods of a and b, which have the same
+// type, can be called: both can be interfaced and neither is nil.
+func canCallMethods(a, b reflect.Value) bool {
+	if !a.CanInterface() || !b.CanInterface() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return false
+		}
+	}
+	return true
+}
+
+// equalsDiffable compares a and b by the Diff method of a. It returns false
+// if either is nil, so they are compared like other values. Only string diffs
+// include the returned differences; other results, like CompareM, have one
+// difference for the values.
+func (c *cmp) equalsDiffable(a, b reflect.Value) bool {
+	if !canCallMethods(a, b) {
+		return false
+	}
+	diffs := a.Interface().(Diffable).Diff(b.Interface())
+	if len(diffs) == 0 {
+		c.saveEqual(a, b)
+		return true
+	}
+	if c.opts.boolOnly || c.opts.asMap || c.opts.asNested || c.opts.asPatch || c.opts.asReport {
+		c.saveDiff(a.Type(), a.Interface(), b.Interface())
+		return true
+	}
+	c.hasDiff = true
+	prefix := c.context()
 	if len(c.buff) > 0 {
-		varName := strings.Join(c.buff, ".")
-		if c.opts.asMap {
-			c.diffM[varName] = DiffResult{
-				OldValue: aval,
-				NewValue: bval,
-			}
-			return
+		prefix += c.path() + ": "
+	}
+	for _, d := range diffs {
+		if c.done() {
+			break
+		}
+		c.appendDiff(prefix + d)
+	}
+	return true
+}
+
+// equalsGoString compares a and b like other values, but reports one
+// difference with their GoString values if they differ. It returns false if
+// either is nil, so they are compared like other values.
+func (c *cmp) equalsGoString(a, b reflect.Value, level int) bool {
+	if !canCallMethods(a, b) {
+		return false
+	}
+	c.opts.PreferGoString = false // compare the values, not this again
+	equal := c.equal(a, b, level)
+	c.opts.PreferGoString = true
+	if equal {
+		c.saveEqual(a, b)
+		return true
+	}
+	c.saveDiff(a.Type(), a.Interface().(fmt.GoStringer).GoString(), b.Interface().(fmt.GoStringer).GoString())
+	return true
+}
+
+// equalsBinary compares the MarshalBinary output of a and b. It returns false
+// if the values cannot be marshaled, so their structure must be compared.
+func (c *cmp) equalsBinary(a, b reflect.Value) bool {
+	if !canCallMethods(a, b) {
+		return false
+	}
+	aBytes, err := a.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return false
+	}
+	bBytes, err := b.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return false
+	}
+	if !bytes.Equal(aBytes, bBytes) {
+		c.saveDiff(a.Type(), a.Interface(), b.Interface())
+	} else {
+		c.saveEqual(a, b)
+	}
+	return true
+}
+
+// equalsReader compares readers a and b by reading them fully. It returns
+// false if they can't be read, like if either is nil, so they are compared
+// like other values.
+func (c *cmp) equalsReader(a, b reflect.Value) bool {
+	if !canCallMethods(a, b) {
+		return false
+	}
+	aBytes, err := io.ReadAll(a.Interface().(io.Reader))
+	if err != nil {
+		c.logError(err)
+		return false
+	}
+	bBytes, err := io.ReadAll(b.Interface().(io.Reader))
+	if err != nil {
+		c.logError(err)
+		return false
+	}
+	if !bytes.Equal(aBytes, bBytes) {
+		c.saveDiff(a.Type(), string(aBytes), string(bBytes))
+	} else {
+		c.saveEqual(a, b)
+	}
+	return true
+}
+
+// equalsGetters compares the results of the getters of a and b, like
+// GetName, named by their method names. It returns false if a and b have no
+// getters that can be called, so they are compared like other values.
+func (c *cmp) equalsGetters(a, b reflect.Value, level int) bool {
+	if !canCallMethods(a, b) {
+		return false
+	}
+	t := a.Type()
+	found := false
+	for i := 0; i < t.NumMethod() && !c.done(); i++ {
+		m := t.Method(i)
+		if !strings.HasPrefix(m.Name, "Get") || m.Type.NumIn() != 1 || m.Type.NumOut() != 1 {
+			continue
+		}
+		aVal, ok := callGetter(a.Method(i))
+		if !ok {
+			continue
+		}
+		bVal, ok := callGetter(b.Method(i))
+		if !ok {
+			continue
+		}
+		found = true
+		c.push(m.Name)
+		c.equals(aVal, bVal, level+1)
+		c.pop()
+	}
+	return found
+}
+
+// equalsAsInterface compares the results of the methods of interface iface,
+// which a and b implement, that take no arguments and return one value. It
+// returns false if there are no such methods or a and b can't be called, so
+// they are a type mismatch.
+func (c *cmp) equalsAsInterface(a, b reflect.Value, iface reflect.Type, level int) bool {
+	if !canCallMethods(a, b) {
+		return false
+	}
+	found := false
+	for i := 0; i < iface.NumMethod() && !c.done(); i++ {
+		m := iface.Method(i)
+		if m.Type.NumIn() != 0 || m.Type.NumOut() != 1 {
+			continue
+		}
+		aVal, ok := callGetter(a.MethodByName(m.Name))
+		if !ok {
+			continue
+		}
+		bVal, ok := callGetter(b.MethodByName(m.Name))
+		if !ok {
+			continue
+		}
+		found = true
+		c.push(m.Name)
+		c.equals(aVal, bVal, level+1)
+		c.pop()
+	}
+	return found
+}
+
+// callGetter returns the value returned by getter, or false if it panics.
+func callGetter(getter reflect.Value) (v reflect.Value, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	return getter.Call(nil)[0], true
+}
+
+// equalsAtomic compares the values returned by the Load methods of sync/atomic
+// values a and b. It returns false if the values have no Load method or it
+// cannot be called.
+func (c *cmp) equalsAtomic(a, b reflect.Value, level int) bool {
+	aLoad := atomicLoad(a)
+	bLoad := atomicLoad(b)
+	if !aLoad.IsValid() || !bLoad.IsValid() {
+		return false
+	}
+	c.equals(aLoad.Call(nil)[0], bLoad.Call(nil)[0], level+1)
+	return true
+}
+
+// atomicLoad returns the Load method of v, which has a pointer receiver. If v
+// is not addressable, the method of a copy is returned.
+func atomicLoad(v reflect.Value) reflect.Value {
+	if !v.CanAddr() {
+		if !v.CanInterface() {
+			return reflect.Value{}
 		}
-		c.diff = append(c.diff, fmt.Sprintf("%s: %v != %v", varName, aval, bval))
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p.Elem()
+	}
+	load := v.Addr().MethodByName("Load")
+	if !load.IsValid() || !load.CanInterface() || load.Type().NumIn() != 0 || load.Type().NumOut() != 1 {
+		return reflect.Value{}
+	}
+	return load
+}
+
+// equalsShallow compares a and b with reflect.DeepEqual, saving one diff for
+// the whole value if they are not equal.
+func (c *cmp) equalsShallow(a, b reflect.Value) {
+	if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+		c.saveDiff(a.Type(), a.Interface(), b.Interface())
 	} else {
-		if c.opts.asMap {
-			c.diffM["result"] = DiffResult{
-				OldValue: aval,
-				NewValue: bval,
+		c.saveEqual(a, b)
+	}
+}
+
+// unwrappable returns true if field f, the only field of a struct, is compared
+// in place of the struct for UnwrapSingleField.
+func (c *cmp) unwrappable(f reflect.StructField) bool {
+	if f.PkgPath != "" && !c.opts.CompareUnexportedFields {
+		return false
+	}
+	if c.opts.SkipSyncPrimitives && syncPrimitiveTypes[f.Type] {
+		return false
+	}
+	return !getTagOpts(f.Tag.Get("compare")).skip && !c.ignoreTagged(f) && !c.ignoreField(f)
+}
+
+// comparator returns the comparator for type t from Comparators or, if none,
+// from the comparators registered with RegisterComparator.
+func (c *cmp) comparator(t reflect.Type) (func(a, b interface{}) bool, bool) {
+	if fn, ok := c.opts.Comparators[t]; ok {
+		return fn, true
+	}
+	fn, ok := c.registered[t]
+	return fn, ok
+}
+
+// seen returns true if pointers a and b were already compared, else it
+// records them for GraphEqual.
+func (c *cmp) seen(a, b reflect.Value) bool {
+	v := visit{a.Pointer(), b.Pointer(), a.Type()}
+	if c.visited[v] {
+		return true
+	}
+	if c.visited == nil {
+		c.visited = map[visit]bool{}
+	}
+	c.visited[v] = true
+	return false
+}
+
+// isMixedPtr returns true if one of types a and b is a pointer to the other.
+func isMixedPtr(a, b reflect.Type) bool {
+	return a.Kind() == reflect.Ptr && a.Elem() == b || b.Kind() == reflect.Ptr && b.Elem() == a
+}
+
+// isZeroTime returns true if v is the zero time.Time.
+func isZeroTime(v reflect.Value) bool {
+	if !v.IsValid() || v.Type() != timeType {
+		return false
+	}
+	if v.CanInterface() {
+		return v.Interface().(time.Time).IsZero()
+	}
+	return v.IsZero()
+}
+
+// isNilPtr returns true if v is a nil pointer.
+func isNilPtr(v reflect.Value) bool {
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// isArrayOrSlice returns true if t is an array or slice type.
+func isArrayOrSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Array || t.Kind() == reflect.Slice
+}
+
+// isPtrChain returns true if t is a pointer to a pointer that, after any
+// number of dereferences, ends in a non-pointer type. Recursive pointer types,
+// like type P *P, are not chains because they never end.
+func isPtrChain(t reflect.Type) bool {
+	if t.Elem().Kind() != reflect.Ptr {
+		return false
+	}
+	seen := map[reflect.Type]bool{}
+	for t.Kind() == reflect.Ptr {
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+		t = t.Elem()
+	}
+	return true
+}
+
+// equal returns true if a and b are equal. It does not save differences.
+// It returns false if the comparison times out, setting c.err.
+func (c *cmp) equal(a, b reflect.Value, level int) bool {
+	sub := &cmp{
+		buff:        append([]pathSeg{}, c.buff...),
+		opts:        c.opts,
+		floatFormat: c.floatFormat,
+		start:       c.start,
+		nodes:       c.nodes,
+		registered:  c.registered,
+	}
+	sub.opts.boolOnly = true
+	sub.opts.OnEnter = nil
+	sub.opts.OnLeave = nil
+	sub.equals(a, b, level)
+	c.nodes = sub.nodes
+	if sub.err != nil {
+		c.err = sub.err
+		return false
+	}
+	return !sub.hasDiff
+}
+
+// equalsUnordered compares slices a and b ignoring the order of elements.
+// Each element in a is matched to the first equal, unmatched element in b.
+func (c *cmp) equalsUnordered(a, b reflect.Value, level int) {
+	n := a.Len()
+	m := b.Len()
+	matched := make([]bool, m)
+	for i := 0; i < n && !c.done(); i++ {
+		found := false
+		c.pushIndex(i)
+		for j := 0; j < m && c.err == nil; j++ {
+			if !matched[j] && c.equal(a.Index(i), b.Index(j), level+1) {
+				matched[j] = true
+				found = true
+				break
 			}
 		}
-		c.diff = append(c.diff, fmt.Sprintf("%v != %v", aval, bval))
+		if !found && c.err == nil {
+			if !c.equalsZero(a.Index(i), true, level+1) {
+				c.saveDiff(dynType(a.Index(i)), a.Index(i), emptyValue)
+			}
+		}
+		c.pop()
+	}
+	for j := 0; j < m && !c.done(); j++ {
+		if !matched[j] {
+			c.pushIndex(j)
+			if !c.equalsZero(b.Index(j), false, level+1) {
+				c.saveDiff(dynType(b.Index(j)), emptyValue, b.Index(j))
+			}
+			c.pop()
+		}
 	}
 }
 
-func (c *cmp) logError(err error) {
-	if c.opts.LogErrors {
-		log.Println(err)
+// equalsMap compares maps a and b, which have the same key type, by key.
+func (c *cmp) equalsMap(a, b reflect.Value, level int) {
+	if (a.IsNil() || b.IsNil()) && !c.opts.LeafDiffsOnly {
+		if a.IsNil() && !b.IsNil() {
+			c.saveDiff(a.Type(), emptyValue, b.Interface())
+		} else if !a.IsNil() && b.IsNil() {
+			c.saveDiff(a.Type(), a.Interface(), emptyValue)
+		}
+		return
+	}
+
+	if a.Pointer() == b.Pointer() && !c.opts.asReport {
+		return
+	}
+
+	if c.opts.CompareHTTPMaps && isHTTPMap(a.Type()) {
+		c.equalsHTTPMap(a, b)
+		return
+	}
+
+	if c.opts.MapKeyNormalize != nil && a.CanInterface() && b.CanInterface() {
+		c.equalsNormalizedMap(a, b, level)
+		return
+	}
+
+	for _, key := range c.mapKeys(a) {
+		c.pushKey(key)
+
+		aVal := a.MapIndex(key)
+		bVal := b.MapIndex(key)
+		if bVal.IsValid() {
+			if !c.opts.MapKeysOnly {
+				c.equals(aVal, bVal, level+1)
+			}
+		} else if c.opts.MapMissingKeyEqualsZero && isZero(aVal) {
+			// equal
+		} else if !c.equalsZero(aVal, true, level+1) {
+			c.saveDiff(dynType(aVal), aVal.Interface(), emptyValue)
+		}
+
+		c.pop()
+
+		if c.done() {
+			return
+		}
+	}
+
+	for _, key := range c.mapKeys(b) {
+		if aVal := a.MapIndex(key); aVal.IsValid() {
+			continue
+		}
+		if c.opts.MapMissingKeyEqualsZero && isZero(b.MapIndex(key)) {
+			continue
+		}
+
+		c.pushKey(key)
+		if !c.equalsZero(b.MapIndex(key), false, level+1) {
+			c.saveDiff(dynType(b.MapIndex(key)), emptyValue, b.MapIndex(key).Interface())
+		}
+		c.pop()
+		if c.done() {
+			return
+		}
 	}
 }
 
-type tagOptions struct {
-	exists bool
-	name   string
-	skip   bool
+// equalsBytes compares byte slices a and b for FastByteSlices.
+func (c *cmp) equalsBytes(a, b reflect.Value) {
+	aBytes, bBytes := a.Bytes(), b.Bytes()
+	if bytes.Equal(aBytes, bBytes) {
+		c.saveEqual(a, b)
+		return
+	}
+	if len(aBytes) != len(bBytes) {
+		c.saveDiff(a.Type(), fmt.Sprintf("len=%d", len(aBytes)), fmt.Sprintf("len=%d", len(bBytes)))
+		return
+	}
+	for i := range aBytes {
+		if aBytes[i] != bBytes[i] {
+			c.pushIndex(i)
+			c.saveDiff(a.Type().Elem(), aBytes[i], bBytes[i])
+			c.pop()
+			return
+		}
+	}
 }
 
-func getTagOpts(tagV string) tagOptions {
-	opts := tagOptions{}
-	if len(tagV) > 0 {
-		opts.exists = true
+// equalsPositional compares the elements of slices or arrays a and b by
+// index. Elements past the end of the shorter one are missing in it.
+func (c *cmp) equalsPositional(a, b reflect.Value, level int) {
+	aLen := a.Len()
+	bLen := b.Len()
+	n := aLen
+	if bLen > aLen {
+		n = bLen
 	}
-	if od := strings.Split(tagV, ","); len(od) > 1 {
-		opts.name = od[0]
-		opts.skip = true
-	} else {
-		opts.name = tagV
+	n = c.maxElems(n)
+	for i := 0; i < n; i++ {
+		c.pushIndex(i)
+		if i < aLen && i < bLen {
+			c.equalsElem(a.Index(i), b.Index(i), level+1)
+		} else if i < aLen {
+			if !c.equalsZero(a.Index(i), true, level+1) {
+				c.saveDiff(dynType(a.Index(i)), a.Index(i), emptyValue)
+			}
+		} else {
+			if !c.equalsZero(b.Index(i), false, level+1) {
+				c.saveDiff(dynType(b.Index(i)), emptyValue, b.Index(i))
+			}
+		}
+		c.pop()
+		if c.done() {
+			return
+		}
+	}
+}
+
+// maxElems returns n, the number of elements to compare by index, or
+// MaxSliceElems if n is greater.
+func (c *cmp) maxElems(n int) int {
+	if c.opts.MaxSliceElems > 0 && n > c.opts.MaxSliceElems {
+		c.logError(ErrSliceTruncated)
+		return c.opts.MaxSliceElems
+	}
+	return n
+}
+
+// equalsElem compares slice elements a and b. If they're pointers and only
+// one is nil, the nil element is reported as "<nil>" and the other as the
+// value it points to, like "#2: <nil> != {1 foo}".
+func (c *cmp) equalsElem(a, b reflect.Value, level int) {
+	if a.Kind() != reflect.Ptr || !nilMismatch(a, b) || c.opts.NilPointerEqualsZero || c.opts.LeafDiffsOnly {
+		c.equals(a, b, level)
+		return
+	}
+	if a.IsNil() {
+		c.saveDiff(a.Type(), nilElement, elemValue(b.Elem()))
+	} else {
+		c.saveDiff(a.Type(), elemValue(a.Elem()), nilElement)
+	}
+}
+
+// elemValue returns the value of v, or v if it can't be interfaced.
+func elemValue(v reflect.Value) interface{} {
+	if v.CanInterface() {
+		return v.Interface()
+	}
+	return v
+}
+
+// equalsZero compares v, which is only in a if inA or else only in b, to the
+// zero value of its type if LeafDiffsOnly is true. It returns false, without
+// comparing, if LeafDiffsOnly is false.
+func (c *cmp) equalsZero(v reflect.Value, inA bool, level int) bool {
+	if !c.opts.LeafDiffsOnly {
+		return false
+	}
+	if inA {
+		c.equals(v, reflect.Zero(v.Type()), level)
+	} else {
+		c.equals(reflect.Zero(v.Type()), v, level)
+	}
+	return true
+}
+
+// isHTTPMap returns true if t is url.Values or http.Header. They're matched by
+// name to not import net/http.
+func isHTTPMap(t reflect.Type) bool {
+	return t.PkgPath() == "net/url" && t.Name() == "Values" ||
+		t.PkgPath() == "net/http" && t.Name() == "Header"
+}
+
+// httpMapValues returns the values of url.Values or http.Header v, sorted,
+// by key. The keys of http.Header are canonicalized, like "Content-Type".
+func httpMapValues(v reflect.Value) map[string][]string {
+	header := v.Type().PkgPath() == "net/http"
+	values := make(map[string][]string, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		if header {
+			key = textproto.CanonicalMIMEHeaderKey(key)
+		}
+		if _, ok := values[key]; !ok {
+			values[key] = []string{} // a key without values is still a key
+		}
+		for i := 0; i < iter.Value().Len(); i++ {
+			values[key] = append(values[key], iter.Value().Index(i).String())
+		}
+	}
+	for _, vals := range values {
+		sort.Strings(vals)
+	}
+	return values
+}
+
+// equalsHTTPMap compares url.Values or http.Header a and b for
+// CompareHTTPMaps. Differing values are reported sorted.
+func (c *cmp) equalsHTTPMap(a, b reflect.Value) {
+	aValues := httpMapValues(a)
+	bValues := httpMapValues(b)
+	keys := make([]string, 0, len(aValues)+len(bValues))
+	for key := range aValues {
+		keys = append(keys, key)
+	}
+	for key := range bValues {
+		if _, ok := aValues[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	t := a.Type().Elem()
+	for _, key := range keys {
+		if c.done() {
+			return
+		}
+		aVals, aOk := aValues[key]
+		bVals, bOk := bValues[key]
+		c.push(key)
+		switch {
+		case !bOk:
+			c.saveDiff(t, aVals, emptyValue)
+		case !aOk:
+			c.saveDiff(t, emptyValue, bVals)
+		case !reflect.DeepEqual(aVals, bVals) && !c.opts.MapKeysOnly:
+			c.saveDiff(t, aVals, bVals)
+		default:
+			c.saveEqual(reflect.ValueOf(aVals), reflect.ValueOf(bVals))
+		}
+		c.pop()
+	}
+}
+
+// equalsNormalizedMap compares maps a and b for MapKeyNormalize, matching
+// keys with the same normalized form.
+func (c *cmp) equalsNormalizedMap(a, b reflect.Value, level int) {
+	bKeys := make(map[interface{}]reflect.Value, b.Len())
+	for _, key := range b.MapKeys() {
+		bKeys[c.opts.MapKeyNormalize(key.Interface())] = key
+	}
+	matched := make(map[interface{}]bool, len(bKeys))
+
+	for _, key := range c.mapKeys(a) {
+		c.pushKey(key)
+		aVal := a.MapIndex(key)
+		norm := c.opts.MapKeyNormalize(key.Interface())
+		if bKey, ok := bKeys[norm]; ok {
+			matched[norm] = true
+			if !c.opts.MapKeysOnly {
+				c.equals(aVal, b.MapIndex(bKey), level+1)
+			}
+		} else if c.opts.MapMissingKeyEqualsZero && isZero(aVal) {
+			// equal
+		} else if !c.equalsZero(aVal, true, level+1) {
+			c.saveDiff(dynType(aVal), aVal.Interface(), emptyValue)
+		}
+		c.pop()
+		if c.done() {
+			return
+		}
+	}
+
+	for _, key := range c.mapKeys(b) {
+		if matched[c.opts.MapKeyNormalize(key.Interface())] {
+			continue
+		}
+		bVal := b.MapIndex(key)
+		if c.opts.MapMissingKeyEqualsZero && isZero(bVal) {
+			continue
+		}
+		c.pushKey(key)
+		if !c.equalsZero(bVal, false, level+1) {
+			c.saveDiff(dynType(bVal), emptyValue, bVal.Interface())
+		}
+		c.pop()
+		if c.done() {
+			return
+		}
+	}
+}
+
+// sliceKeyField returns the index of the struct field tagged as the identity
+// key, like `compare:"id,key"`, if t is a struct with one. The key must be an
+// exported, comparable field.
+func sliceKeyField(t reflect.Type) (int, bool) {
+	if t.Kind() != reflect.Struct {
+		return 0, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || !f.Type.Comparable() {
+			continue
+		}
+		if getTagOpts(f.Tag.Get("compare")).key {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// equalsByKey compares slices a and b of structs by matching elements with
+// the same key field, regardless of their order. Elements in a without a
+// match in b are removed, and elements in b without a match in a are added.
+// It returns false if the keys can't be used, like in an unexported field or
+// holding a slice, so the slices must be compared by index.
+func (c *cmp) equalsByKey(a, b reflect.Value, key int, level int) bool {
+	if !a.CanInterface() || !b.CanInterface() {
+		return false
+	}
+	aKeys, ok := sliceKeys(a, key)
+	if !ok {
+		return false
+	}
+	bKeys, ok := sliceKeys(b, key)
+	if !ok {
+		return false
+	}
+	n := a.Len()
+	m := b.Len()
+	bIndex := make(map[interface{}]int, m)
+	for j := m - 1; j >= 0; j-- {
+		bIndex[bKeys[j]] = j
+	}
+	matched := make([]bool, m)
+	for i := 0; i < n && !c.done(); i++ {
+		c.pushIndex(i)
+		j, ok := bIndex[aKeys[i]]
+		if ok && !matched[j] {
+			matched[j] = true
+			c.equals(a.Index(i), b.Index(j), level+1)
+		} else {
+			if !c.equalsZero(a.Index(i), true, level+1) {
+				c.saveDiff(dynType(a.Index(i)), a.Index(i), emptyValue)
+			}
+		}
+		c.pop()
+	}
+	for j := 0; j < m && !c.done(); j++ {
+		if !matched[j] {
+			c.pushIndex(j)
+			if !c.equalsZero(b.Index(j), false, level+1) {
+				c.saveDiff(dynType(b.Index(j)), emptyValue, b.Index(j))
+			}
+			c.pop()
+		}
+	}
+	return true
+}
+
+// sliceKeys returns the values of key field key of the elements of slice v,
+// or false if one can't be a map key, like an interface holding a slice.
+func sliceKeys(v reflect.Value, key int) ([]interface{}, bool) {
+	keys := make([]interface{}, v.Len())
+	for i := range keys {
+		k := v.Index(i).Field(key)
+		if !k.Comparable() {
+			return nil, false
+		}
+		keys[i] = k.Interface()
+	}
+	return keys, true
+}
+
+// sliceLess returns a func that returns true if value i sorts before value j
+// of type t, or nil if t has no order.
+func (c *cmp) sliceLess(t reflect.Type) func(i, j reflect.Value) bool {
+	if less, ok := c.opts.SliceLess[t]; ok {
+		return func(i, j reflect.Value) bool {
+			if !i.CanInterface() || !j.CanInterface() {
+				return false
+			}
+			return less(i.Interface(), j.Interface())
+		}
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(i, j reflect.Value) bool { return i.Int() < j.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(i, j reflect.Value) bool { return i.Uint() < j.Uint() }
+	case reflect.Float32, reflect.Float64:
+		return func(i, j reflect.Value) bool { return i.Float() < j.Float() }
+	case reflect.String:
+		return func(i, j reflect.Value) bool { return i.String() < j.String() }
+	}
+	return nil
+}
+
+// sortedIndexes returns the indexes of slice v in sorted order.
+func sortedIndexes(v reflect.Value, less func(i, j reflect.Value) bool) []int {
+	idx := make([]int, v.Len())
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return less(v.Index(idx[i]), v.Index(idx[j]))
+	})
+	return idx
+}
+
+// equalsSorted compares slices a and b by index after sorting them. The
+// slices are not modified, and paths are indexes in sorted order.
+func (c *cmp) equalsSorted(a, b reflect.Value, less func(i, j reflect.Value) bool, level int) {
+	aIdx := sortedIndexes(a, less)
+	bIdx := sortedIndexes(b, less)
+	n := len(aIdx)
+	if len(bIdx) > n {
+		n = len(bIdx)
+	}
+	for i := 0; i < n && !c.done(); i++ {
+		c.pushIndex(i)
+		if i < len(aIdx) && i < len(bIdx) {
+			c.equals(a.Index(aIdx[i]), b.Index(bIdx[i]), level+1)
+		} else if i < len(aIdx) {
+			if !c.equalsZero(a.Index(aIdx[i]), true, level+1) {
+				c.saveDiff(dynType(a.Index(aIdx[i])), a.Index(aIdx[i]), emptyValue)
+			}
+		} else {
+			if !c.equalsZero(b.Index(bIdx[i]), false, level+1) {
+				c.saveDiff(dynType(b.Index(bIdx[i])), emptyValue, b.Index(bIdx[i]))
+			}
+		}
+		c.pop()
+	}
+}
+
+// equalsLCS compares slices a and b by their longest common subsequence
+// (LCS). Elements not in the LCS are reported as added, removed, or, if
+// both slices have one at the same point, changed.
+func (c *cmp) equalsLCS(a, b reflect.Value, level int) {
+	n := a.Len()
+	m := b.Len()
+
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	eq := make([][]bool, n)
+	lcs := make([][]int, n+1)
+	lcs[n] = make([]int, m+1)
+	for i := n - 1; i >= 0; i-- {
+		if c.err != nil {
+			return
+		}
+		eq[i] = make([]bool, m)
+		lcs[i] = make([]int, m+1)
+		for j := m - 1; j >= 0 && c.err == nil; j-- {
+			c.pushIndex(i)
+			eq[i][j] = c.equal(a.Index(i), b.Index(j), level+1)
+			c.pop()
+			if eq[i][j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for (i < n || j < m) && !c.done() {
+		switch {
+		case i < n && j < m && eq[i][j]:
+			i++
+			j++
+		case i < n && j < m && lcs[i+1][j+1] == lcs[i][j]:
+			c.pushIndex(i)
+			c.equals(a.Index(i), b.Index(j), level+1)
+			c.pop()
+			i++
+			j++
+		case j < m && (i == n || lcs[i][j+1] >= lcs[i+1][j]):
+			c.pushIndex(j)
+			if !c.equalsZero(b.Index(j), false, level+1) {
+				c.saveDiff(dynType(b.Index(j)), emptyValue, b.Index(j))
+			}
+			c.pop()
+			j++
+		default:
+			c.pushIndex(i)
+			if !c.equalsZero(a.Index(i), true, level+1) {
+				c.saveDiff(dynType(a.Index(i)), a.Index(i), emptyValue)
+			}
+			c.pop()
+			i++
+		}
+	}
+}
+
+// equalsSoft compares a and b, which are different types, by value if they
+// are both strings, bools, or numbers. It returns false if their kinds are
+// incompatible.
+func (c *cmp) equalsSoft(a, b reflect.Value) bool {
+	aKind := a.Kind()
+	bKind := b.Kind()
+	var equal bool
+	switch {
+	case aKind == reflect.String && bKind == reflect.String:
+		equal = c.normalizeString(a.String()) == c.normalizeString(b.String())
+	case aKind == reflect.Bool && bKind == reflect.Bool:
+		equal = a.Bool() == b.Bool()
+	case isNumber(aKind) && isNumber(bKind):
+		equal = c.numbersEqual(a, b)
+	default:
+		return false
+	}
+	if equal {
+		c.saveEqual(a, b)
+		return true
+	}
+	c.saveDiff(a.Type(),
+		fmt.Sprintf("%v (%s)", a, typeName(a.Type())),
+		fmt.Sprintf("%v (%s)", b, typeName(b.Type())))
+	return true
+}
+
+// equalsByFieldName compares the fields of structs a and b, which are
+// different types, that have the same name and kind. Fields are matched by
+// name, not index, so they can be declared in any order. Fields promoted from
+// embedded structs are not matched.
+func (c *cmp) equalsByFieldName(a, b reflect.Value, level int) {
+	aType := a.Type()
+	bType := b.Type()
+	c.pushStruct(aType)
+	defer c.popStruct()
+	bFields := make(map[string]int, bType.NumField())
+	for j := 0; j < bType.NumField(); j++ {
+		bFields[bType.Field(j).Name] = j
+	}
+	for i := 0; i < a.NumField(); i++ {
+		aField := aType.Field(i)
+		if aField.PkgPath != "" && !c.opts.CompareUnexportedFields {
+			c.saveSkip(aField.Name, "unexported")
+			continue
+		}
+		if c.opts.SkipSyncPrimitives && syncPrimitiveTypes[aField.Type] {
+			c.saveSkip(aField.Name, "sync primitive")
+			continue
+		}
+		j, ok := bFields[aField.Name]
+		if !ok || bType.Field(j).Type.Kind() != aField.Type.Kind() {
+			continue
+		}
+
+		tagOpts := getTagOpts(aField.Tag.Get("compare"))
+		if tagOpts.skip || c.ignoreTagged(aField) {
+			c.saveSkip(aField.Name, "tag")
+			continue
+		}
+		if c.ignoreField(aField) {
+			c.saveSkip(aField.Name, "ignored")
+			continue
+		}
+		if tagOpts.exists {
+			c.push(tagOpts.name)
+		} else {
+			c.push(aField.Name)
+		}
+		af := a.Field(i)
+		bf := b.Field(j)
+		if tagOpts.shallow && af.CanInterface() && bf.CanInterface() {
+			c.equalsShallow(af, bf)
+		} else {
+			c.equals(af, bf, level+1)
+		}
+		c.pop()
+
+		if c.done() {
+			break
+		}
+	}
+}
+
+// pathSeg is one name in the path to the compared values: a struct field
+// name, a map key, or a slice or array index. Keys and indexes are kept as
+// values and only formatted when the path is rendered, like by saveDiff, so
+// comparing equal maps and slices doesn't format every key and index.
+type pathSeg struct {
+	kind  segKind
+	name  string
+	key   reflect.Value
+	index int
+	typ   reflect.Type // for DiffResult.TypePath
+}
+
+type segKind int
+
+const (
+	segName segKind = iota
+	segKey
+	segIndex
+)
+
+func (c *cmp) push(name string) {
+	c.pushSeg(pathSeg{kind: segName, name: name})
+}
+
+// pushKey pushes map key to buff.
+func (c *cmp) pushKey(key reflect.Value) {
+	c.pushSeg(pathSeg{kind: segKey, key: key})
+}
+
+// pushIndex pushes slice or array index i, rendered like "#1", to buff.
+func (c *cmp) pushIndex(i int) {
+	c.pushSeg(pathSeg{kind: segIndex, index: i})
+}
+
+func (c *cmp) pushSeg(seg pathSeg) {
+	c.buff = append(c.buff, seg)
+	if len(c.buff) < len(c.pathDiffs) {
+		c.pathDiffs[len(c.buff)] = 0 // new container
+	}
+	if c.opts.OnEnter != nil {
+		c.opts.OnEnter(c.names())
+	}
+}
+
+// recordType records type t of the value being compared at the current path
+// for DiffResult.TypePath.
+func (c *cmp) recordType(t reflect.Type) {
+	if n := len(c.buff); n > 0 {
+		c.buff[n-1].typ = t
+	} else {
+		c.rootType = t
+	}
+}
+
+// typePath returns the recorded types along the current path for a diff of
+// values of type t.
+func (c *cmp) typePath(t reflect.Type) []reflect.Type {
+	types := make([]reflect.Type, 0, len(c.buff)+1)
+	if c.rootType != nil {
+		types = append(types, c.rootType)
+	} else {
+		types = append(types, t)
+	}
+	for i, seg := range c.buff {
+		if seg.typ == nil && i == len(c.buff)-1 {
+			seg.typ = t // diff saved without comparing the values
+		}
+		types = append(types, seg.typ)
+	}
+	return types
+}
+
+func (c *cmp) pop() {
+	if len(c.buff) > 0 {
+		if c.opts.OnLeave != nil {
+			c.opts.OnLeave(c.names())
+		}
+		c.buff = c.buff[0 : len(c.buff)-1]
+	}
+}
+
+// segString returns the rendered name of seg.
+func (c *cmp) segString(seg pathSeg) string {
+	switch seg.kind {
+	case segKey:
+		return c.mapKeyName(seg.key)
+	case segIndex:
+		return "#" + strconv.Itoa(seg.index)
+	}
+	return seg.name
+}
+
+// names returns the rendered names in buff.
+func (c *cmp) names() []string {
+	names := make([]string, len(c.buff))
+	for i, seg := range c.buff {
+		names[i] = c.segString(seg)
+	}
+	return names
+}
+
+// pushStruct pushes struct type t, which contains the values being compared.
+func (c *cmp) pushStruct(t reflect.Type) {
+	c.structs = append(c.structs, t)
+}
+
+func (c *cmp) popStruct() {
+	c.structs = c.structs[:len(c.structs)-1]
+}
+
+// pushContext pushes the values of ContextFields in struct v, like "[ID=42]",
+// which identify diffs in the struct. It's a no-op without ContextFields.
+func (c *cmp) pushContext(v reflect.Value) {
+	if len(c.opts.ContextFields) == 0 {
+		return
+	}
+	ctx := []string{}
+	for _, name := range c.opts.ContextFields {
+		f := v.FieldByName(name)
+		if !f.IsValid() || !f.CanInterface() {
+			continue
+		}
+		ctx = append(ctx, fmt.Sprintf("[%s=%v]", name, f.Interface()))
+	}
+	c.ctx = append(c.ctx, strings.Join(ctx, " "))
+}
+
+func (c *cmp) popContext() {
+	if len(c.ctx) > 0 {
+		c.ctx = c.ctx[0 : len(c.ctx)-1]
+	}
+}
+
+// context returns the context of the innermost struct that has any.
+func (c *cmp) context() string {
+	for i := len(c.ctx) - 1; i >= 0; i-- {
+		if c.ctx[i] != "" {
+			return c.ctx[i] + " "
+		}
+	}
+	return ""
+}
+
+func (c *cmp) pathSeparator() string {
+	if c.opts.PathSeparator == "" {
+		return "."
+	}
+	return c.opts.PathSeparator
+}
+
+// mapKeys returns the keys of map v, sorted if DeterministicMapOrder is set,
+// and at most MaxMapKeys of them.
+func (c *cmp) mapKeys(v reflect.Value) []reflect.Value {
+	if n := c.opts.MaxMapKeys; n > 0 && v.Len() > n {
+		c.logError(ErrMapTruncated)
+		if less := keyLess(v.Type().Key()); less != nil {
+			return sortKeys(smallestKeys(v, n, less))
+		}
+		return sortKeys(v.MapKeys())[:n]
+	}
+	keys := v.MapKeys()
+	if c.opts.DeterministicMapOrder {
+		sortKeys(keys)
+	}
+	return keys
+}
+
+// sortKeys sorts map keys by name and returns them.
+func sortKeys(keys []reflect.Value) []reflect.Value {
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = fmt.Sprintf("%v", key)
+	}
+	sort.Sort(keysByName{keys, names})
+	return keys
+}
+
+// keyLess returns a function that orders map keys of type t by value, or nil
+// if t isn't a number, string, or bool.
+func keyLess(t reflect.Type) func(a, b reflect.Value) bool {
+	switch t.Kind() {
+	case reflect.String:
+		return func(a, b reflect.Value) bool { return a.String() < b.String() }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(a, b reflect.Value) bool { return a.Int() < b.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }
+	case reflect.Float32, reflect.Float64:
+		return func(a, b reflect.Value) bool { return a.Float() < b.Float() }
+	case reflect.Bool:
+		return func(a, b reflect.Value) bool { return !a.Bool() && b.Bool() }
+	}
+	return nil
+}
+
+// smallestKeys returns the n smallest keys of map v by less, unordered. Only
+// those n keys are kept, so large maps are truncated without sorting them.
+func smallestKeys(v reflect.Value, n int, less func(a, b reflect.Value) bool) []reflect.Value {
+	h := &keyHeap{keys: make([]reflect.Value, 0, n), less: less}
+	key := reflect.New(v.Type().Key()).Elem()
+	iter := v.MapRange()
+	for iter.Next() {
+		if len(h.keys) < n {
+			heap.Push(h, iter.Key())
+			continue
+		}
+		key.SetIterKey(iter)
+		if less(key, h.keys[0]) {
+			h.keys[0] = iter.Key()
+			heap.Fix(h, 0)
+		}
+	}
+	return h.keys
+}
+
+// keyHeap is a max-heap of map keys for smallestKeys.
+type keyHeap struct {
+	keys []reflect.Value
+	less func(a, b reflect.Value) bool
+}
+
+func (h *keyHeap) Len() int           { return len(h.keys) }
+func (h *keyHeap) Less(i, j int) bool { return h.less(h.keys[j], h.keys[i]) }
+func (h *keyHeap) Swap(i, j int)      { h.keys[i], h.keys[j] = h.keys[j], h.keys[i] }
+func (h *keyHeap) Push(x interface{}) { h.keys = append(h.keys, x.(reflect.Value)) }
+func (h *keyHeap) Pop() interface{} {
+	key := h.keys[len(h.keys)-1]
+	h.keys = h.keys[:len(h.keys)-1]
+	return key
+}
+
+// keysByName sorts map keys by their names. Keys of interface types, like in
+// map[interface{}]interface{}, with the same name, like 1 and "1", are sorted
+// by the names of their dynamic types.
+type keysByName struct {
+	keys  []reflect.Value
+	names []string
+}
+
+func (k keysByName) Len() int { return len(k.keys) }
+func (k keysByName) Less(i, j int) bool {
+	if k.names[i] != k.names[j] {
+		return k.names[i] < k.names[j]
+	}
+	return dynType(k.keys[i]).String() < dynType(k.keys[j]).String()
+}
+func (k keysByName) Swap(i, j int) {
+	k.keys[i], k.keys[j] = k.keys[j], k.keys[i]
+	k.names[i], k.names[j] = k.names[j], k.names[i]
+}
+
+// mapKeyName returns the path name for a map key of any kind, bracketing
+// keys that contain the path separator.
+func (c *cmp) mapKeyName(key reflect.Value) string {
+	name := fmt.Sprintf("%v", key)
+	if strings.Contains(name, c.pathSeparator()) {
+		return fmt.Sprintf("[%q]", name)
+	}
+	return name
+}
+
+// path returns the current path rendered in the PathStyle.
+func (c *cmp) path() string {
+	switch c.opts.PathStyle {
+	case JQ:
+		return c.jqPath()
+	case JSONPointer:
+		return c.jsonPointer()
+	}
+	return c.dottedPath()
+}
+
+func (c *cmp) dottedPath() string {
+	return strings.Join(c.names(), c.pathSeparator())
+}
+
+// jqPath returns the current path as a jq selector.
+func (c *cmp) jqPath() string {
+	var b strings.Builder
+	for _, seg := range c.buff {
+		switch seg.kind {
+		case segIndex:
+			fmt.Fprintf(&b, "[%d]", seg.index)
+		case segKey:
+			if name := fmt.Sprintf("%v", seg.key); isIdentifier(name) {
+				b.WriteString("." + name)
+			} else {
+				fmt.Fprintf(&b, "[%q]", name)
+			}
+		default:
+			b.WriteString("." + seg.name)
+		}
+	}
+	return b.String()
+}
+
+// jsonPointerEscaper escapes a JSON Pointer reference token.
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// jsonPointer returns the current path as a JSON Pointer.
+func (c *cmp) jsonPointer() string {
+	var b strings.Builder
+	for _, seg := range c.buff {
+		b.WriteString("/")
+		switch seg.kind {
+		case segIndex:
+			b.WriteString(strconv.Itoa(seg.index))
+		case segKey:
+			b.WriteString(jsonPointerEscaper.Replace(fmt.Sprintf("%v", seg.key)))
+		default:
+			b.WriteString(jsonPointerEscaper.Replace(seg.name))
+		}
+	}
+	return b.String()
+}
+
+// isIdentifier returns true if s is a jq identifier, like "foo_1".
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// saveLeafDiff is like saveDiff for placeholders aval and bval, like types,
+// of values a and b, which are invalid if nil. Patches and reports have a and
+// b instead of the placeholders.
+func (c *cmp) saveLeafDiff(t reflect.Type, aval, bval interface{}, a, b reflect.Value) {
+	c.leaves = []reflect.Value{a, b}
+	c.saveDiff(t, aval, bval)
+	c.leaves = nil
+}
+
+// saveDiff saves a difference between aval and bval, which are values of
+// type t or placeholders for them.
+func (c *cmp) saveDiff(t reflect.Type, aval, bval interface{}) {
+	if c.opts.asSchema {
+		_, aok := aval.(reflect.Type)
+		_, bok := bval.(reflect.Type)
+		if !aok || !bok {
+			return // not a type mismatch, like a nil pointer in a or b
+		}
+	}
+	if c.opts.IgnoreBothZero && c.bothZero(aval, bval) {
+		return
+	}
+	c.hasDiff = true
+	if c.opts.boolOnly {
+		return
+	}
+	c.countPathDiff()
+	if c.opts.asCounts && len(c.structs) > 0 {
+		c.typeCounts[c.structs[len(c.structs)-1]]++
+	}
+	if c.opts.asNested {
+		c.saveNestedDiff(t, aval, bval)
+		return
+	}
+	if c.opts.asPatch {
+		c.savePatch(aval, bval)
+		return
+	}
+	if c.opts.asReport {
+		if c.leaves != nil {
+			aval, bval = c.leaves[0], c.leaves[1]
+		}
+		c.report = append(c.report, FieldReport{
+			Path: c.path(),
+			Old:  reportValue(aval),
+			New:  reportValue(bval),
+		})
+		return
+	}
+	if len(c.buff) > 0 {
+		varName := c.path()
+		if c.opts.asMap {
+			c.diffM[varName] = DiffResult{
+				Path:     varName,
+				OldValue: diffValue(aval),
+				NewValue: diffValue(bval),
+				Kind:     kindOf(t),
+				Delta:    delta(t, aval, bval),
+				TypePath: c.typePath(t),
+			}
+			return
+		}
+		if c.opts.AnnotateTypes && t != nil {
+			varName += " (" + typeName(t) + ")"
+		}
+		if c.typeChange != "" {
+			c.appendDiff(fmt.Sprintf("%s%s: %s %v -> %v%s", c.context(), varName, c.typeChange, c.formatValue(aval), c.formatValue(bval), c.typeValues))
+			return
+		}
+		c.appendDiff(fmt.Sprintf("%s%s: %v != %v", c.context(), varName, c.formatValue(aval), c.formatValue(bval)))
+	} else {
+		if c.opts.asMap {
+			c.diffM["result"] = DiffResult{
+				OldValue: diffValue(aval),
+				NewValue: diffValue(bval),
+				Kind:     kindOf(t),
+				Delta:    delta(t, aval, bval),
+				TypePath: c.typePath(t),
+			}
+		}
+		if c.opts.AnnotateTypes && t != nil {
+			c.appendDiff(fmt.Sprintf("(%s) %v != %v", typeName(t), c.formatValue(aval), c.formatValue(bval)))
+			return
+		}
+		c.appendDiff(fmt.Sprintf("%v != %v", c.formatValue(aval), c.formatValue(bval)))
+	}
+}
+
+// saveTypeChange saves a difference between the types of values a and b of
+// interface iface. In string diffs, it's a type change for empty interfaces,
+// like "Value: type changed int -> string", and a change of variant for other
+// interfaces, which are like unions of the types that implement them, like
+// "Payload: variant main.Text -> main.Image". Type changes of map values
+// include the values, like `foo: type int -> string (1 -> "1")`.
+func (c *cmp) saveTypeChange(iface reflect.Type, a, b reflect.Value) {
+	c.typeChange = "type changed"
+	if iface.NumMethod() > 0 {
+		c.typeChange = "variant"
+	} else if n := len(c.buff); n > 0 && c.buff[n-1].kind == segKey && a.CanInterface() && b.CanInterface() {
+		c.typeChange = "type"
+		c.typeValues = fmt.Sprintf(" (%#v -> %#v)", a.Interface(), b.Interface())
+	}
+	c.saveLeafDiff(a.Type(), a.Type(), b.Type(), a, b)
+	c.typeChange = ""
+	c.typeValues = ""
+}
+
+// appendDiff appends string diff d for the current path.
+func (c *cmp) appendDiff(d string) {
+	c.diff = append(c.diff, d)
+	c.diffPaths = append(c.diffPaths, c.path())
+	if c.opts.DiffRank != nil {
+		c.diffRanks = append(c.diffRanks, c.opts.DiffRank(c.names()))
+	}
+	if c.opts.asDivergence {
+		c.divergeAt(c.names())
+	}
+	if c.opts.asGrouped {
+		group := ""
+		if len(c.buff) > 0 {
+			group = c.segString(c.buff[0])
+		}
+		c.diffG[group] = append(c.diffG[group], d)
+	}
+}
+
+// divergeAt shortens common, the path names shared by all diffs, to the
+// names it shares with path, the names of a new diff.
+func (c *cmp) divergeAt(path []string) {
+	if len(c.diff) == 1 {
+		c.common = path
+		return
+	}
+	n := 0
+	for n < len(c.common) && n < len(path) && c.common[n] == path[n] {
+		n++
+	}
+	c.common = c.common[:n]
+}
+
+// saveNestedDiff saves the diff in diffN, creating a map for each name in
+// buff except the last.
+func (c *cmp) saveNestedDiff(t reflect.Type, aval, bval interface{}) {
+	d := DiffResult{
+		Path:     c.path(),
+		OldValue: diffValue(aval),
+		NewValue: diffValue(bval),
+		Kind:     kindOf(t),
+		Delta:    delta(t, aval, bval),
+		TypePath: c.typePath(t),
+	}
+	if len(c.buff) == 0 {
+		c.diffN["result"] = d
+		return
+	}
+	m := c.diffN
+	names := c.names()
+	for _, name := range names[:len(names)-1] {
+		next, ok := m[name].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[name] = next
+		}
+		m = next
+	}
+	m[names[len(names)-1]] = d
+}
+
+// saveSkip records that the value at the current path plus name, if not
+// empty, was not compared for reason if TraceSkips is true.
+func (c *cmp) saveSkip(name, reason string) {
+	if !c.opts.TraceSkips || c.opts.boolOnly {
+		return
+	}
+	if name != "" {
+		c.buff = append(c.buff, pathSeg{kind: segName, name: name})
+		defer func() { c.buff = c.buff[:len(c.buff)-1] }()
+	}
+	c.skips = append(c.skips, Skip{Path: c.path(), Reason: reason})
+}
+
+// saveEqual records equal leaf values a and b in report mode.
+func (c *cmp) saveEqual(a, b reflect.Value) {
+	if !c.opts.asReport {
+		return
+	}
+	c.report = append(c.report, FieldReport{
+		Path:  c.path(),
+		Old:   reportValue(a),
+		New:   reportValue(b),
+		Equal: true,
+	})
+}
+
+func (c *cmp) savePatch(aval, bval interface{}) {
+	if c.leaves != nil {
+		bval = c.leaves[1]
+	}
+	op := PatchOp{Op: "replace", Path: c.path(), Value: patchValue(bval)}
+	if aval == emptyValue {
+		op.Op = "add"
+	} else if bval == emptyValue {
+		op.Op = "remove"
+		op.Value = nil
+	}
+	c.patch = append(c.patch, op)
+}
+
+// delta returns bval minus aval if both are numbers of the same kind, or nil.
+// Differences of values of type t time.Duration are durations.
+func delta(t reflect.Type, aval, bval interface{}) interface{} {
+	switch a := aval.(type) {
+	case int64:
+		if b, ok := bval.(int64); ok {
+			if t == durationType {
+				return time.Duration(b - a)
+			}
+			return b - a
+		}
+	case uint64:
+		if b, ok := bval.(uint64); ok {
+			return int64(b - a)
+		}
+	case float64:
+		if b, ok := bval.(float64); ok {
+			return b - a
+		}
+	case time.Duration:
+		if b, ok := bval.(time.Duration); ok {
+			return b - a
+		}
+	}
+	return nil
+}
+
+func kindOf(t reflect.Type) reflect.Kind {
+	if t == nil {
+		return reflect.Invalid
+	}
+	return t.Kind()
+}
+
+// dynType returns the type of the value in v if v is a non-nil interface,
+// else the type of v.
+func dynType(v reflect.Value) reflect.Type {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		return v.Elem().Type()
+	}
+	return v.Type()
+}
+
+// diffValue returns v with placeholders converted to strings.
+func diffValue(v interface{}) interface{} {
+	if p, ok := v.(placeholder); ok {
+		return string(p)
+	}
+	return v
+}
+
+// dump returns the type of v and v formatted by the ValueFormatter for
+// DumpOnMismatch.
+func (c *cmp) dump(v reflect.Value) string {
+	format := c.opts.ValueFormatter
+	if format == nil {
+		format = formatIndented
+	}
+	return typeName(v.Type()) + " " + format(v.Interface())
+}
+
+// formatIndented formats v as indented JSON, or like "%+v" if it can't be
+// marshaled.
+func formatIndented(v interface{}) string {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return string(b)
+}
+
+// formatValue returns v to format in a string diff. Types are formatted by
+// typeName, and other values as JSON if JSONValues is set.
+func (c *cmp) formatValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case reflect.Type:
+		return typeName(v)
+	case placeholder:
+		return v
+	}
+	if !c.opts.JSONValues {
+		return v
+	}
+	x := v
+	if rv, ok := v.(reflect.Value); ok {
+		if !rv.CanInterface() {
+			return v
+		}
+		x = rv.Interface()
+	}
+	js, err := json.Marshal(x)
+	if err != nil {
+		return v
+	}
+	return string(js)
+}
+
+// pkgPathPrefix matches the package path before a package name, like
+// "github.com/chaelub/" in "github.com/chaelub/deep.Options".
+var pkgPathPrefix = regexp.MustCompile(`[\w.~-]+/`)
+
+// typeName returns the name of type t, like t.String(), but without package
+// paths in the type arguments of generic types, so Box[pkg.T] isn't rendered
+// as Box[github.com/user/pkg.T].
+func typeName(t reflect.Type) string {
+	name := t.String()
+	if !strings.Contains(t.Name(), "[") {
+		return name
+	}
+	i := strings.Index(name, "[")
+	return name[:i] + pkgPathPrefix.ReplaceAllString(name[i:], "")
+}
+
+// bothZero returns true if the values of a diff shown as aval and bval are
+// zero or missing for IgnoreBothZero.
+func (c *cmp) bothZero(aval, bval interface{}) bool {
+	if c.leaves != nil {
+		return isZero(c.leaves[0]) && isZero(c.leaves[1])
+	}
+	return isZeroDiffValue(aval) && isZeroDiffValue(bval)
+}
+
+// isZeroDiffValue returns true if diff value v is a zero value or a
+// placeholder for a missing value.
+func isZeroDiffValue(v interface{}) bool {
+	switch v := v.(type) {
+	case placeholder:
+		return true
+	case reflect.Value:
+		return isZero(v)
+	}
+	return isZero(reflect.ValueOf(v))
+}
+
+// reportValue returns v for a FieldReport, converting a reflect.Value to the
+// value it holds. Values of unexported fields are formatted as strings.
+func reportValue(v interface{}) interface{} {
+	rv, ok := v.(reflect.Value)
+	if !ok {
+		return diffValue(v)
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	if rv.CanInterface() {
+		return rv.Interface()
+	}
+	return fmt.Sprint(rv)
+}
+
+// patchValue returns the value held by v if it's a reflect.Value.
+func patchValue(v interface{}) interface{} {
+	rv, ok := v.(reflect.Value)
+	if ok && !rv.IsValid() {
+		return nil
+	}
+	if ok && rv.CanInterface() {
+		return rv.Interface()
+	}
+	return diffValue(v)
+}
+
+func (c *cmp) logError(err error) {
+	if c.opts.LogErrors {
+		log.Println(err)
+	}
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// numberValue returns the value of number v as an int64, uint64, or float64.
+func numberValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	}
+	return v.Float()
+}
+
+// numbersEqual compares numbers a and b, which can be different kinds, by
+// value. If either is a float, both are compared like floats.
+func (c *cmp) numbersEqual(a, b reflect.Value) bool {
+	if isFloat(a.Kind()) || isFloat(b.Kind()) {
+		aval := fmt.Sprintf(c.floatFormat, floatValue(a))
+		bval := fmt.Sprintf(c.floatFormat, floatValue(b))
+		return aval == bval
+	}
+	return intValue(a).Cmp(intValue(b)) == 0
+}
+
+func floatValue(v reflect.Value) float64 {
+	switch n := numberValue(v).(type) {
+	case int64:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	default:
+		return n.(float64)
+	}
+}
+
+func intValue(v reflect.Value) *big.Int {
+	if n, ok := numberValue(v).(uint64); ok {
+		return new(big.Int).SetUint64(n)
+	}
+	return big.NewInt(v.Int())
+}
+
+// belowNoiseFloor returns true if a and b differ by less than the
+// NumericNoiseFloor.
+func (c *cmp) belowNoiseFloor(a, b float64) bool {
+	return c.opts.NumericNoiseFloor > 0 && math.Abs(a-b) < c.opts.NumericNoiseFloor
+}
+
+// floatsIdentical returns true if a and b have the same bits or are both NaN.
+func floatsIdentical(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Float64bits(a) == math.Float64bits(b)
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// enumName returns the name of enum value v, or v if it has no name.
+func enumName(names map[int64]string, v int64) interface{} {
+	if name, ok := names[v]; ok {
+		return name
+	}
+	return v
+}
+
+// isEnum returns true if v is an integer kind that implements fmt.Stringer.
+func isEnum(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.CanInterface() && v.Type().Implements(stringerType)
+	}
+	return false
+}
+
+func isByteArray(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8
+}
+
+// arrayBytes returns a copy of the bytes in byte array v. It works for
+// unaddressable arrays, which cannot be sliced.
+func arrayBytes(v reflect.Value) []byte {
+	buf := make([]byte, v.Len())
+	for i := range buf {
+		buf[i] = byte(v.Index(i).Uint())
+	}
+	return buf
+}
+
+// stringsEqual returns true if strings a and b are equal after normalizeString,
+// ignoring case if the PathRule for the current path is CaseInsensitive.
+func (c *cmp) stringsEqual(a, b string) bool {
+	a, b = c.normalizeString(a), c.normalizeString(b)
+	if len(c.opts.PathRules) > 0 && c.opts.PathRules[c.dottedPath()].CaseInsensitive {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// normalizeString returns s as compared by the string options, like
+// StringTrimPrefixes and StringIgnoreWhitespace.
+func (c *cmp) normalizeString(s string) string {
+	if c.opts.NormalizeLineEndings {
+		s = lineEndings.Replace(s)
+	}
+	for _, prefix := range c.opts.StringTrimPrefixes {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	for _, suffix := range c.opts.StringTrimSuffixes {
+		s = strings.TrimSuffix(s, suffix)
+	}
+	if c.opts.StringIgnoreWhitespace {
+		s = strings.Join(strings.Fields(s), " ")
+	}
+	return s
+}
+
+// lineEndings replaces Windows and old Mac line endings with "\n".
+var lineEndings = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// runesString returns the string of slice v of runes.
+func runesString(v reflect.Value) string {
+	runes := make([]rune, v.Len())
+	for i := range runes {
+		runes[i] = rune(v.Index(i).Int())
+	}
+	return string(runes)
+}
+
+// ignoreField returns true if IgnoreField ignores field.
+func (c *cmp) ignoreField(field reflect.StructField) bool {
+	return c.opts.IgnoreField != nil && c.opts.IgnoreField(field)
+}
+
+// ignoreTagged returns true if field has the IgnoreTag.
+func (c *cmp) ignoreTagged(field reflect.StructField) bool {
+	if c.opts.IgnoreTag == "" {
+		return false
+	}
+	_, ok := field.Tag.Lookup(c.opts.IgnoreTag)
+	return ok
+}
+
+type tagOptions struct {
+	exists  bool
+	name    string
+	skip    bool
+	shallow bool
+	key     bool
+}
+
+// getTagOpts parses a compare struct tag like `compare:"name,option"`. The
+// "shallow" option compares the field with reflect.DeepEqual instead of
+// recursing into it. The "key" option marks the field as the identity key of
+// its struct in slices, which are then compared by key instead of by index.
+// Any other option, like "skip", skips the field.
+func getTagOpts(tagV string) tagOptions {
+	opts := tagOptions{}
+	od := strings.Split(tagV, ",")
+	opts.name = od[0]
+	opts.exists = opts.name != ""
+	for _, o := range od[1:] {
+		switch o {
+		case "shallow":
+			opts.shallow = true
+		case "key":
+			opts.key = true
+		default:
+			opts.skip = true
+		}
 	}
 	return opts
 }
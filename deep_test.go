@@ -1,11 +1,20 @@
 package deep_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/chaelub/deep"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
 	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -290,8 +299,8 @@ func TestMaxDiff(t *testing.T) {
 }
 
 func TestNotHandled(t *testing.T) {
-	a := func(int) {}
-	b := func(int) {}
+	a := make(chan int)
+	b := make(chan int)
 	diff, _ := deep.CompareS(a, b)
 	if len(diff) > 0 {
 		t.Error("got diffs:", diff)
@@ -884,3 +893,4302 @@ func TestNil(t *testing.T) {
 		t.Error("Nil value to comparison should not be equal")
 	}
 }
+
+func TestDiffResultPath(t *testing.T) {
+	type s2 struct {
+		Nickname string
+	}
+	type s1 struct {
+		Name  string
+		Alias s2
+		Age   int
+	}
+	sa := s1{Name: "Robert", Alias: s2{Nickname: "Bob"}, Age: 40}
+	sb := s1{Name: "Rob", Alias: s2{Nickname: "Bobby"}, Age: 41}
+	diffM, got := deep.CompareM(sa, sb)
+	if !got {
+		t.Fatal("no diff")
+	}
+
+	diffs := []deep.DiffResult{}
+	for key, d := range diffM {
+		if d.Path != key {
+			t.Errorf("got path %s, expected %s", d.Path, key)
+		}
+		diffs = append(diffs, d)
+	}
+	deep.SortDiffs(diffs)
+
+	expected := []string{"Age", "Alias.Nickname", "Name"}
+	if len(diffs) != len(expected) {
+		t.Fatalf("got %d diffs, expected %d: %v", len(diffs), len(expected), diffs)
+	}
+	for i, path := range expected {
+		if diffs[i].Path != path {
+			t.Errorf("diff %d: got path %s, expected %s", i, diffs[i].Path, path)
+		}
+	}
+}
+
+func TestBytesAsNumber(t *testing.T) {
+	a := [8]byte{0, 0, 0, 0, 0, 0, 1, 0}
+	b := [4]byte{0, 0, 1, 0}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "[8]uint8 != [4]uint8" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.BytesAsNumber = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	c := [8]byte{0, 0, 0, 0, 0, 0, 1, 1}
+	diff, _ = deep.CompareS(a, c, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "256 != 257" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestNilPointerEqualsZero(t *testing.T) {
+	var a *int
+	zero := 0
+	five := 5
+
+	diff, _ := deep.CompareS(a, &zero)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "<nil pointer> != int" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.NilPointerEqualsZero = true
+	diff, _ = deep.CompareS(a, &zero, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(&zero, a, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(a, &five, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "<nil pointer> != int" {
+		t.Error("wrong diff:", diff[0])
+	}
+	diff, _ = deep.CompareS(&five, a, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "int != <nil pointer>" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestPathSeparator(t *testing.T) {
+	a := map[string]map[string]int{
+		"a.b": {"c": 1},
+	}
+	b := map[string]map[string]int{
+		"a.b": {"c": 2},
+	}
+
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != `["a.b"].c: 1 != 2` {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.PathSeparator = "/"
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "a.b/c: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Missing keys are bracketed too
+	delete(b, "a.b")
+	b["x.y"] = map[string]int{}
+	diff, _ = deep.CompareS(a, b)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != `["a.b"]: map[c:1] != [empty value]` {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != `["x.y"]: [empty value] != map[]` {
+		t.Error("wrong diff:", diff[1])
+	}
+}
+
+func TestReflectType(t *testing.T) {
+	diff, _ := deep.CompareS(reflect.TypeOf(1), reflect.TypeOf(2))
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(reflect.TypeOf(1), reflect.TypeOf(""))
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "int != string" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// As a field
+	type schema struct {
+		Type reflect.Type
+	}
+	diff, _ = deep.CompareS(schema{reflect.TypeOf(1)}, schema{reflect.TypeOf(1.0)})
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Type: int != float64" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(schema{nil}, schema{reflect.TypeOf(1)})
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Type: <nil pointer> != int" {
+		t.Error("wrong diff:", diff[0])
+	}
+	diff, _ = deep.CompareS(schema{reflect.TypeOf(1)}, schema{nil})
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Type: int != <nil pointer>" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Structs embedding reflect.Type are compared like other structs
+	type wrappedType struct {
+		reflect.Type
+	}
+	diff, _ = deep.CompareS(wrappedType{reflect.TypeOf(1)}, wrappedType{reflect.TypeOf("")})
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Type: int != string" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+type Email string
+
+func TestCanonicalizers(t *testing.T) {
+	type user struct {
+		Name  string
+		Email Email
+	}
+	a := user{Name: "Bob", Email: "Bob@Example.com"}
+	b := user{Name: "Bob", Email: "bob@example.com"}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.Canonicalizers = map[reflect.Type]func(interface{}) interface{}{
+		reflect.TypeOf(Email("")): func(v interface{}) interface{} {
+			return Email(strings.ToLower(string(v.(Email))))
+		},
+	}
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Email = "robert@example.com"
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Email: bob@example.com != robert@example.com" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	type item struct {
+		SKU string
+		Qty int
+	}
+	type order struct {
+		ID    int
+		Name  string
+		Items []item
+	}
+	a := order{ID: 42, Name: "foo", Items: []item{{SKU: "x1", Qty: 1}}}
+	b := order{ID: 42, Name: "bar", Items: []item{{SKU: "x1", Qty: 2}}}
+
+	opts := deep.DefaultOptions
+	opts.ContextFields = []string{"ID", "SKU"}
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "[ID=42] Name: foo != bar" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "[SKU=x1] Items.#0.Qty: 1 != 2" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	// Without the option
+	diff, _ = deep.CompareS(a, b)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Name: foo != bar" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestCompareBool(t *testing.T) {
+	type s1 struct {
+		Name    string
+		Numbers []int
+	}
+	a := s1{Name: "foo", Numbers: []int{1, 2, 3}}
+	b := s1{Name: "foo", Numbers: []int{1, 2, 3}}
+	if deep.CompareBool(a, b) {
+		t.Error("should be equal")
+	}
+
+	b.Numbers[2] = 333
+	if !deep.CompareBool(a, b) {
+		t.Error("should differ")
+	}
+
+	if deep.CompareBool(nil, nil) {
+		t.Error("should be equal")
+	}
+	if !deep.CompareBool(a, nil) {
+		t.Error("should differ")
+	}
+}
+
+type benchItem struct {
+	ID    int
+	Name  string
+	Score float64
+	Tags  []string
+	Attrs map[string]int
+}
+
+func benchItems(n int) []benchItem {
+	items := make([]benchItem, n)
+	for i := range items {
+		items[i] = benchItem{
+			ID:    i,
+			Name:  fmt.Sprintf("item%d", i),
+			Score: float64(i) / 3,
+			Tags:  []string{"a", "b", "c"},
+			Attrs: map[string]int{"x": i, "y": i * 2},
+		}
+	}
+	return items
+}
+
+func BenchmarkCompareS(b *testing.B) {
+	opts := deep.DefaultOptions
+	opts.MaxDepth = 100
+	x, y := benchItems(1000), benchItems(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		deep.CompareS(x, y, opts)
+	}
+}
+
+func BenchmarkCompareBool(b *testing.B) {
+	opts := deep.DefaultOptions
+	opts.MaxDepth = 100
+	x, y := benchItems(1000), benchItems(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		deep.CompareBool(x, y, opts)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	type meta struct {
+		CreatedAt time.Time
+		ID        *string
+	}
+	type doc struct {
+		Name   string
+		Meta   meta
+		Ignore *string `compare:"Ignore,skip"`
+		Attrs  map[string]string
+	}
+	id1, id2 := "a1", "b2"
+	now := time.Now()
+	a := doc{
+		Name:  "foo",
+		Meta:  meta{CreatedAt: now, ID: &id1},
+		Attrs: map[string]string{"uuid": "a1"},
+	}
+	b := doc{
+		Name:  "foo",
+		Meta:  meta{CreatedAt: now.Add(time.Hour), ID: &id2},
+		Attrs: map[string]string{"uuid": "b2"},
+	}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 3 {
+		t.Fatalf("expected 3 diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.Redact = []string{"Meta.CreatedAt", "Meta.ID", "Attrs.uuid"}
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	// Redacted values must be present on both sides, unlike skipped fields
+	b.Meta.ID = nil
+	delete(b.Attrs, "uuid")
+	b.Ignore = &id2
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Meta.ID: string != <nil pointer>" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "Attrs.uuid: a1 != [empty value]" {
+		t.Error("wrong diff:", diff[1])
+	}
+}
+
+func TestCompareMNested(t *testing.T) {
+	type s1 struct {
+		Name string
+		Tags map[string]string
+	}
+	a := s1{Name: "foo", Tags: map[string]string{"env": "dev", "team": "x"}}
+	b := s1{Name: "bar", Tags: map[string]string{"env": "prod", "team": "x"}}
+
+	diffN, got := deep.CompareMNested(a, b)
+	if !got {
+		t.Fatal("no diff")
+	}
+	if len(diffN) != 2 {
+		t.Errorf("expected 2 top-level entries, got %d: %v", len(diffN), diffN)
+	}
+	name, ok := diffN["Name"].(deep.DiffResult)
+	if !ok {
+		t.Fatalf("Name is %T, expected deep.DiffResult", diffN["Name"])
+	}
+	if name.OldValue != "foo" || name.NewValue != "bar" {
+		t.Error("wrong Name diff:", name)
+	}
+	tags, ok := diffN["Tags"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Tags is %T, expected map[string]interface{}", diffN["Tags"])
+	}
+	if len(tags) != 1 {
+		t.Errorf("expected 1 Tags entry, got %d: %v", len(tags), tags)
+	}
+	env, ok := tags["env"].(deep.DiffResult)
+	if !ok {
+		t.Fatalf("Tags.env is %T, expected deep.DiffResult", tags["env"])
+	}
+	if env.Path != "Tags.env" || env.OldValue != "dev" || env.NewValue != "prod" {
+		t.Error("wrong Tags.env diff:", env)
+	}
+	types := []reflect.Type{reflect.TypeOf(a), reflect.TypeOf(a.Tags), reflect.TypeOf("")}
+	if !reflect.DeepEqual(env.TypePath, types) {
+		t.Errorf("got TypePath %v, expected %v", env.TypePath, types)
+	}
+
+	diffN, got = deep.CompareMNested(a, a)
+	if got || diffN != nil {
+		t.Error("should be equal:", diffN)
+	}
+}
+
+func TestByFieldName(t *testing.T) {
+	type user struct {
+		ID       int
+		Name     string
+		Age      int
+		Password string
+	}
+	type userDTO struct {
+		Name string
+		Age  int
+		ID   string // different kind
+	}
+	a := user{ID: 1, Name: "Bob", Age: 40, Password: "secret"}
+	b := userDTO{Name: "Bob", Age: 40, ID: "1"}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "deep_test.user != deep_test.userDTO" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.ByFieldName = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Age = 41
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Age: 40 != 41" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestCompareE(t *testing.T) {
+	diff, err := deep.CompareE("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "foo != bar" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Calling Error on an unexported field panics
+	type tWithError struct {
+		err error
+	}
+	opts := deep.DefaultOptions
+	opts.CompareUnexportedFields = true
+	a := tWithError{err: errors.New("it broke")}
+	b := tWithError{err: errors.New("it fell apart")}
+	diff, err = deep.CompareE(a, b, opts)
+	if !errors.Is(err, deep.ErrPanic) {
+		t.Errorf("got error %v, expected deep.ErrPanic", err)
+	}
+	if diff != nil {
+		t.Error("expected nil diff:", diff)
+	}
+}
+
+func TestEnumNames(t *testing.T) {
+	type Status int
+	type account struct {
+		Status Status
+	}
+	a := account{Status: 1}
+	b := account{Status: 2}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Status: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.EnumNames = map[reflect.Type]map[int64]string{
+		reflect.TypeOf(Status(0)): {1: "Active", 2: "Pending"},
+	}
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Status: Active != Pending" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Unnamed values are shown as numbers
+	b.Status = 3
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Status: Active != 3" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(a, a, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+type slowInt int
+
+func TestTimeout(t *testing.T) {
+	a := make([]slowInt, 500)
+	b := make([]slowInt, 500)
+	opts := deep.DefaultOptions
+	opts.Canonicalizers = map[reflect.Type]func(interface{}) interface{}{
+		reflect.TypeOf(slowInt(0)): func(v interface{}) interface{} {
+			time.Sleep(time.Millisecond)
+			return v
+		},
+	}
+	opts.Timeout = 5 * time.Millisecond
+
+	start := time.Now()
+	diff, err := deep.CompareE(a, b, opts)
+	if err != deep.ErrTimeout {
+		t.Errorf("got error %v, expected deep.ErrTimeout", err)
+	}
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("took %s, expected the comparison to stop early", elapsed)
+	}
+
+	// Differences found before the timeout are returned
+	a[0] = 1
+	diff, err = deep.CompareE(a, b, opts)
+	if err != deep.ErrTimeout {
+		t.Errorf("got error %v, expected deep.ErrTimeout", err)
+	}
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "#0: 1 != 0" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Elements are matched by sub-comparisons, which also time out
+	type list struct {
+		Items []slowInt
+	}
+	for _, set := range []func(*deep.Options){
+		func(o *deep.Options) { o.SliceLCS = true },
+		func(o *deep.Options) { o.OrderIndependentPaths = []string{"Items"} },
+	} {
+		a := list{make([]slowInt, 40)}
+		b := list{make([]slowInt, 40)}
+		for i := range b.Items {
+			a.Items[i] = slowInt(i)
+			b.Items[i] = slowInt(-i)
+		}
+		listOpts := opts
+		set(&listOpts)
+		start := time.Now()
+		_, err := deep.CompareE(a, b, listOpts)
+		if err != deep.ErrTimeout {
+			t.Errorf("got error %v, expected deep.ErrTimeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+			t.Errorf("took %s, expected the comparison to stop early", elapsed)
+		}
+	}
+}
+
+func TestMapNonStringKeys(t *testing.T) {
+	type point struct {
+		X, Y int
+	}
+	a := map[point]string{
+		{1, 2}: "a",
+		{3, 4}: "b",
+	}
+	b := map[point]string{
+		{1, 2}: "a",
+		{5, 6}: "c",
+	}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "{3 4}: b != [empty value]" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "{5 6}: [empty value] != c" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	// Keys with different dynamic types are different keys
+	c := map[interface{}]int{
+		1:     1,
+		"one": 1,
+	}
+	d := map[interface{}]int{
+		int64(1): 1,
+		"one":    2,
+	}
+	diff, _ = deep.CompareS(c, d)
+	if len(diff) != 3 {
+		t.Fatalf("expected 3 diffs, got %d: %s", len(diff), diff)
+	}
+	expected := map[string]bool{
+		"1: 1 != [empty value]": true,
+		"one: 1 != 2":           true,
+		"1: [empty value] != 1": true,
+	}
+	for _, d := range diff {
+		if !expected[d] {
+			t.Error("wrong diff:", d)
+		}
+	}
+}
+
+func TestMapIntKeys(t *testing.T) {
+	a := map[int]int{1: 1, 2: 2}
+	b := map[int]int{1: 1, 2: 2, 3: 3}
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "3: [empty value] != 3" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(b, a)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "3: 3 != [empty value]" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestSkipSyncPrimitives(t *testing.T) {
+	type counter struct {
+		sync.Mutex
+		wg    sync.WaitGroup
+		Count int
+	}
+	a := &counter{Count: 1}
+	b := &counter{Count: 2}
+	b.Lock()
+	defer b.Unlock()
+	b.wg.Add(1)
+	defer b.wg.Done()
+
+	opts := deep.DefaultOptions
+	opts.CompareUnexportedFields = true
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) < 2 {
+		t.Fatalf("expected mutex and wait group diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts.SkipSyncPrimitives = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Count: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestComparePatch(t *testing.T) {
+	type config struct {
+		Name  string
+		Attrs map[string]int
+		Tags  []string
+	}
+	a := config{
+		Name:  "foo",
+		Attrs: map[string]int{"keep": 1, "old": 2},
+		Tags:  []string{"x"},
+	}
+	b := config{
+		Name:  "bar",
+		Attrs: map[string]int{"keep": 1, "new": 3},
+		Tags:  []string{"x", "y"},
+	}
+	patch, got := deep.ComparePatch(a, b)
+	if !got {
+		t.Fatal("no diff")
+	}
+	expected := []deep.PatchOp{
+		{Op: "replace", Path: "Name", Value: "bar"},
+		{Op: "remove", Path: "Attrs.old"},
+		{Op: "add", Path: "Attrs.new", Value: 3},
+		{Op: "add", Path: "Tags.#1", Value: "y"},
+	}
+	if !reflect.DeepEqual(patch, expected) {
+		t.Errorf("got patch %v, expected %v", patch, expected)
+	}
+
+	patch, got = deep.ComparePatch(a, a)
+	if got || patch != nil {
+		t.Error("should be equal:", patch)
+	}
+
+	// Values, not the placeholders shown by CompareS
+	x := 1
+	type ptr struct {
+		P *int
+	}
+	for _, tc := range []struct {
+		a, b   interface{}
+		expect []deep.PatchOp
+	}{
+		{
+			map[string]interface{}{"a": 1},
+			map[string]interface{}{"a": "x"},
+			[]deep.PatchOp{{Op: "replace", Path: "a", Value: "x"}},
+		},
+		{ptr{nil}, ptr{&x}, []deep.PatchOp{{Op: "replace", Path: "P", Value: 1}}},
+		{ptr{&x}, ptr{nil}, []deep.PatchOp{{Op: "replace", Path: "P", Value: nil}}},
+		{nil, ptr{}, []deep.PatchOp{{Op: "replace", Path: "", Value: ptr{}}}},
+	} {
+		patch, _ := deep.ComparePatch(tc.a, tc.b)
+		if !reflect.DeepEqual(patch, tc.expect) {
+			t.Errorf("%v -> %v: got patch %#v, expected %#v", tc.a, tc.b, patch, tc.expect)
+		}
+	}
+
+	// CompareM still returns placeholders as strings
+	diffM, _ := deep.CompareM(a, b)
+	if diffM["Attrs.old"].NewValue != "[empty value]" {
+		t.Errorf("got %#v, expected \"[empty value]\"", diffM["Attrs.old"].NewValue)
+	}
+}
+
+// fraction marshals to its reduced form, so 1/2 and 2/4 are the same.
+type fraction struct {
+	Num, Den int
+}
+
+func (f fraction) MarshalBinary() ([]byte, error) {
+	if f.Den == 0 {
+		return nil, errors.New("zero denominator")
+	}
+	a, b := f.Num, f.Den
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return []byte(fmt.Sprintf("%d/%d", f.Num/a, f.Den/a)), nil
+}
+
+func TestCompareBinaryMarshaler(t *testing.T) {
+	type recipe struct {
+		Sugar fraction
+	}
+	a := recipe{Sugar: fraction{1, 2}}
+	b := recipe{Sugar: fraction{2, 4}}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.CompareBinaryMarshaler = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Sugar = fraction{3, 4}
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Sugar: {1 2} != {3 4}" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Marshal errors fall back to comparing the structure
+	b.Sugar = fraction{1, 0}
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Sugar.Den: 2 != 0" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestSliceLCS(t *testing.T) {
+	a := []int{1, 2, 3, 4}
+	b := []int{0, 1, 2, 3, 4}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 5 {
+		t.Fatalf("expected 5 diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.SliceLCS = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "#0: [empty value] != 0" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(b, a, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "#0: 0 != [empty value]" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Changed elements are compared
+	type item struct {
+		Name string
+		Qty  int
+	}
+	c := []item{{"a", 1}, {"b", 1}, {"c", 1}}
+	d := []item{{"new", 1}, {"a", 1}, {"b", 2}, {"c", 1}}
+	diff, _ = deep.CompareS(c, d, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "#0: [empty value] != {new 1}" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "#1.Qty: 1 != 2" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	diff, _ = deep.CompareS(a, a[:], opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestOrderIndependentPaths(t *testing.T) {
+	type groups struct {
+		Members map[string][]string
+		Order   []string
+	}
+	a := groups{
+		Members: map[string][]string{"admins": {"ann", "bob"}},
+		Order:   []string{"x", "y"},
+	}
+	b := groups{
+		Members: map[string][]string{"admins": {"bob", "ann"}},
+		Order:   []string{"y", "x"},
+	}
+
+	opts := deep.DefaultOptions
+	opts.OrderIndependentPaths = []string{"Members.admins"}
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Order.#0: x != y" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "Order.#1: y != x" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	b.Members["admins"] = []string{"bob", "cat", "ann"}
+	b.Order = a.Order
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Members.admins.#1: [empty value] != cat" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestCompareValuesInvalid(t *testing.T) {
+	var invalid reflect.Value
+
+	diff, got := deep.CompareValues(invalid, invalid)
+	if got || len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareValues(invalid, reflect.ValueOf(1))
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "<nil pointer> != int" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareValues(reflect.ValueOf("foo"), invalid)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "string != <nil pointer>" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Elem of a nil interface is invalid
+	var i1, i2 interface{}
+	diff, _ = deep.CompareValues(reflect.ValueOf(&i1).Elem().Elem(), reflect.ValueOf(&i2).Elem().Elem())
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareValues(reflect.ValueOf(1), reflect.ValueOf(2))
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestAutoDerefMixed(t *testing.T) {
+	type User struct {
+		Name string
+		Age  int
+	}
+	a := User{Name: "Bob", Age: 40}
+	b := &User{Name: "Bob", Age: 40}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "deep_test.User != *deep_test.User" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.AutoDerefMixed = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(b, a, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Age = 41
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Age: 40 != 41" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	var nilUser *User
+	diff, _ = deep.CompareS(a, nilUser, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "deep_test.User != <nil pointer>" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestDiffResultKind(t *testing.T) {
+	type address struct {
+		City string
+	}
+	type person struct {
+		Name    string
+		Age     int
+		Address *address
+		Attrs   map[string]interface{}
+	}
+	a := person{
+		Name:    "Bob",
+		Age:     40,
+		Address: &address{City: "Paris"},
+		Attrs:   map[string]interface{}{"score": 1.5},
+	}
+	b := person{
+		Name:  "Robert",
+		Age:   41,
+		Attrs: map[string]interface{}{},
+	}
+	diffM, _ := deep.CompareM(a, b)
+	expected := map[string]reflect.Kind{
+		"Name":        reflect.String,
+		"Age":         reflect.Int,
+		"Address":     reflect.Struct,
+		"Attrs.score": reflect.Float64,
+	}
+	if len(diffM) != len(expected) {
+		t.Fatalf("got %d diffs, expected %d: %v", len(diffM), len(expected), diffM)
+	}
+	for path, kind := range expected {
+		if diffM[path].Kind != kind {
+			t.Errorf("%s: got kind %s, expected %s", path, diffM[path].Kind, kind)
+		}
+	}
+}
+
+func TestCompareAtomics(t *testing.T) {
+	type stats struct {
+		Hits atomic.Int64
+		Last atomic.Value
+	}
+	a := &stats{}
+	b := &stats{}
+	a.Hits.Store(10)
+	b.Hits.Store(10)
+	a.Last.Store("foo")
+	b.Last.Store("foo")
+
+	opts := deep.DefaultOptions
+	opts.CompareAtomics = true
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Hits.Store(11)
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Hits: 10 != 11" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Without the option, atomics are not compared
+	diff, _ = deep.CompareS(a, b)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Hits.Store(10)
+	b.Last.Store("bar")
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Last: foo != bar" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestShallowTag(t *testing.T) {
+	type blob struct {
+		A, B, C int
+		Nested  map[string][]int
+	}
+	type config struct {
+		Name   string
+		Blob   blob `compare:",shallow"`
+		Backup blob `compare:"backup,shallow"`
+	}
+	a := config{
+		Name: "foo",
+		Blob: blob{A: 1, B: 2, C: 3, Nested: map[string][]int{"x": {1, 2}}},
+	}
+	b := config{
+		Name: "foo",
+		Blob: blob{A: 1, B: 2, C: 3, Nested: map[string][]int{"x": {1, 2}}},
+	}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Blob = blob{A: 10, B: 20, C: 30, Nested: map[string][]int{"x": {3}}}
+	b.Backup.A = 1
+	diff, _ = deep.CompareS(a, b)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Blob: {1 2 3 map[x:[1 2]]} != {10 20 30 map[x:[3]]}" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "backup: {0 0 0 map[]} != {1 0 0 map[]}" {
+		t.Error("wrong diff:", diff[1])
+	}
+}
+
+func TestDeterministicMapOrder(t *testing.T) {
+	type key struct {
+		Name string
+		N    int
+	}
+	a := map[key]int{}
+	b := map[key]int{}
+	for i := 0; i < 20; i++ {
+		a[key{fmt.Sprintf("k%02d", i), i}] = i
+		b[key{fmt.Sprintf("k%02d", i), i}] = i + 1
+	}
+
+	opts := deep.DefaultOptions
+	opts.DeterministicMapOrder = true
+	opts.MaxDiff = 5
+	first, _ := deep.CompareS(a, b, opts)
+	expected := []string{
+		"{k00 0}: 0 != 1",
+		"{k01 1}: 1 != 2",
+		"{k02 2}: 2 != 3",
+		"{k03 3}: 3 != 4",
+		"{k04 4}: 4 != 5",
+	}
+	if !reflect.DeepEqual(first, expected) {
+		t.Fatalf("got %v, expected %v", first, expected)
+	}
+	for i := 0; i < 20; i++ {
+		diff, _ := deep.CompareS(a, b, opts)
+		if !reflect.DeepEqual(diff, first) {
+			t.Fatalf("run %d: got %v, expected %v", i, diff, first)
+		}
+	}
+}
+
+func TestCompareJSONValues(t *testing.T) {
+	a := map[string]interface{}{
+		"bar": 1,
+	}
+	b := map[string]interface{}{
+		"bar": 1.0,
+	}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	diff, _ = deep.CompareJSONValues(a, b)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b["bar"] = 1.23
+	diff, _ = deep.CompareJSONValues(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "bar: 1 != 1.23" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Hand-built vs decoded
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(`{"n": 2, "u": 3, "tags": [], "nested": {"f": 0.5}}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	var tags []interface{}
+	expected := map[string]interface{}{
+		"n":      2,
+		"u":      uint8(3),
+		"tags":   tags,
+		"nested": map[string]interface{}{"f": float32(0.5)},
+	}
+	diff, _ = deep.CompareJSONValues(decoded, expected)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestKeyTag(t *testing.T) {
+	type Item struct {
+		ID    int `compare:"id,key"`
+		Name  string
+		Count int
+	}
+	type order struct {
+		Items []Item
+	}
+	a := order{
+		Items: []Item{
+			{ID: 1, Name: "foo", Count: 1},
+			{ID: 2, Name: "bar", Count: 2},
+			{ID: 3, Name: "baz", Count: 3},
+		},
+	}
+	b := order{
+		Items: []Item{
+			{ID: 3, Name: "baz", Count: 3},
+			{ID: 1, Name: "foo", Count: 1},
+			{ID: 2, Name: "bar", Count: 2},
+		},
+	}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Items[2].Count = 20
+	b.Items[0] = Item{ID: 4, Name: "qux", Count: 4}
+	diff, _ = deep.CompareS(a, b)
+	if len(diff) != 3 {
+		t.Fatalf("expected 3 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Items.#1.Count: 2 != 20" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "Items.#2: {3 baz 3} != [empty value]" {
+		t.Error("wrong diff:", diff[1])
+	}
+	if diff[2] != "Items.#0: [empty value] != {4 qux 4}" {
+		t.Error("wrong diff:", diff[2])
+	}
+}
+
+func TestKeyTagFallback(t *testing.T) {
+	type Item struct {
+		ID   int `compare:"id,key"`
+		Name string
+	}
+	type order struct {
+		items []Item
+	}
+	a := order{items: []Item{{1, "foo"}, {2, "bar"}}}
+	b := order{items: []Item{{2, "bar"}, {1, "foo"}}}
+
+	// Keys of unexported fields can't be interfaced, so the items are
+	// compared by index
+	opts := deep.DefaultOptions
+	opts.CompareUnexportedFields = true
+	diff, _ := deep.CompareS(a, b, opts)
+	expect := []string{
+		"items.#0.id: 1 != 2",
+		"items.#0.Name: foo != bar",
+		"items.#1.id: 2 != 1",
+		"items.#1.Name: bar != foo",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	// Keys that can't be map keys
+	type Entry struct {
+		Key interface{} `compare:"key,key"`
+		Val int
+	}
+	x := []Entry{{[]int{1}, 1}, {"b", 2}}
+	y := []Entry{{[]int{1}, 1}, {"b", 3}}
+	diff, _ = deep.CompareS(x, y)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "#1.Val: 2 != 3" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestPointerChainDepth(t *testing.T) {
+	opts := deep.DefaultOptions
+	opts.MaxDepth = 1
+	n1, n2 := 1, 1
+	p1, p2 := &n1, &n2
+	pp1, pp2 := &p1, &p2
+	a, b := &pp1, &pp2
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	n2 = 2
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Nil pointers in the chain are still detected
+	p2 = nil
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "int != <nil pointer>" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestReport(t *testing.T) {
+	type address struct {
+		City string
+		Zip  string
+	}
+	type person struct {
+		Name    string
+		Age     int
+		Active  bool
+		Address address
+		Tags    []string
+	}
+	a := person{
+		Name:    "foo",
+		Age:     30,
+		Active:  true,
+		Address: address{City: "Paris", Zip: "75001"},
+		Tags:    []string{"x"},
+	}
+	b := person{
+		Name:    "foo",
+		Age:     31,
+		Active:  true,
+		Address: address{City: "Lyon", Zip: "75001"},
+		Tags:    []string{"x", "y"},
+	}
+	expect := []deep.FieldReport{
+		{Path: "Name", Old: "foo", New: "foo", Equal: true},
+		{Path: "Age", Old: int64(30), New: int64(31)},
+		{Path: "Active", Old: true, New: true, Equal: true},
+		{Path: "Address.City", Old: "Paris", New: "Lyon"},
+		{Path: "Address.Zip", Old: "75001", New: "75001", Equal: true},
+		{Path: "Tags.#0", Old: "x", New: "x", Equal: true},
+		{Path: "Tags.#1", Old: "[empty value]", New: "y"},
+	}
+	report := deep.Report(a, b)
+	if !reflect.DeepEqual(report, expect) {
+		t.Errorf("got %+v, expected %+v", report, expect)
+	}
+
+	// Equal values are reported too
+	report = deep.Report(a, a)
+	if len(report) != 6 {
+		t.Fatalf("expected 6 fields, got %d: %+v", len(report), report)
+	}
+	for _, r := range report {
+		if !r.Equal {
+			t.Error("should be equal:", r)
+		}
+	}
+
+	// Values, not the types shown by CompareS
+	type leaves struct {
+		P *int
+		V interface{}
+	}
+	x := 1
+	expect = []deep.FieldReport{
+		{Path: "P", Old: 1, New: nil},
+		{Path: "V", Old: 1, New: "x"},
+	}
+	report = deep.Report(leaves{&x, 1}, leaves{nil, "x"})
+	if !reflect.DeepEqual(report, expect) {
+		t.Errorf("got %+v, expected %+v", report, expect)
+	}
+}
+
+func TestIgnoreValue(t *testing.T) {
+	type session struct {
+		ID    string
+		User  string
+		Token string
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	opts := deep.DefaultOptions
+	opts.IgnoreValue = func(path []string, a, b interface{}) bool {
+		as, ok := a.(string)
+		return ok && uuid.MatchString(as)
+	}
+	a := session{
+		ID:    "0b0bfbb8-7e8a-4d5c-9f1e-2a3b4c5d6e7f",
+		User:  "foo",
+		Token: "2c9e2d1a-0f3b-4e6a-8b7c-1d2e3f4a5b6c",
+	}
+	b := session{
+		ID:    "5f4e3d2c-1b0a-4f9e-8d7c-6b5a4f3e2d1c",
+		User:  "bar",
+		Token: "not-a-uuid",
+	}
+	diff, _ := deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "User: foo != bar" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Path is passed to the predicate
+	var paths []string
+	opts.IgnoreValue = func(path []string, a, b interface{}) bool {
+		paths = append(paths, strings.Join(path, "."))
+		return false
+	}
+	deep.CompareS(a, a, opts)
+	expect := []string{"", "ID", "User", "Token"}
+	if !reflect.DeepEqual(paths, expect) {
+		t.Errorf("got paths %q, expected %q", paths, expect)
+	}
+}
+
+func TestRunesAsString(t *testing.T) {
+	a := []rune("héllo")
+	b := []rune("hello")
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "#1: 233 != 101" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.RunesAsString = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "héllo != hello" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(a, []rune("héllo"), opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestOnEnterOnLeave(t *testing.T) {
+	type inner struct {
+		X, Y int
+	}
+	type outer struct {
+		Name  string
+		Inner inner
+		List  []inner
+		Tags  map[string]int
+	}
+	a := outer{
+		Name:  "foo",
+		Inner: inner{X: 1, Y: 2},
+		List:  []inner{{X: 3}, {Y: 4}},
+		Tags:  map[string]int{"env": 1},
+	}
+	b := a
+	b.List = []inner{{X: 3}, {Y: 4}}
+	b.Tags = map[string]int{"env": 2}
+
+	depth := 0
+	var entered []string
+	opts := deep.DefaultOptions
+	opts.OnEnter = func(path []string) {
+		depth++
+		entered = append(entered, strings.Join(path, "."))
+	}
+	opts.OnLeave = func(path []string) {
+		depth--
+		if depth < 0 {
+			t.Error("left more than entered at", path)
+		}
+	}
+	deep.CompareS(a, b, opts)
+	if depth != 0 {
+		t.Errorf("entered %d more than left", depth)
+	}
+	expect := []string{
+		"Name",
+		"Inner", "Inner.X", "Inner.Y",
+		"List", "List.#0", "List.#0.X", "List.#0.Y", "List.#1", "List.#1.X", "List.#1.Y",
+		"Tags", "Tags.env",
+	}
+	if !reflect.DeepEqual(entered, expect) {
+		t.Errorf("got %q, expected %q", entered, expect)
+	}
+}
+
+func TestCompareGrouped(t *testing.T) {
+	type address struct {
+		City, Zip string
+	}
+	type contact struct {
+		Email, Phone string
+	}
+	type person struct {
+		Name    string
+		Address address
+		Contact contact
+	}
+	a := person{
+		Name:    "foo",
+		Address: address{City: "Paris", Zip: "75001"},
+		Contact: contact{Email: "foo@example.com", Phone: "123"},
+	}
+	b := person{
+		Name:    "foo",
+		Address: address{City: "Lyon", Zip: "69001"},
+		Contact: contact{Email: "bar@example.com", Phone: "123"},
+	}
+	groups, _ := deep.CompareGrouped(a, b)
+	expect := map[string][]string{
+		"Address": {
+			"Address.City: Paris != Lyon",
+			"Address.Zip: 75001 != 69001",
+		},
+		"Contact": {
+			"Contact.Email: foo@example.com != bar@example.com",
+		},
+	}
+	if !reflect.DeepEqual(groups, expect) {
+		t.Errorf("got %q, expected %q", groups, expect)
+	}
+
+	groups, _ = deep.CompareGrouped(1, 2)
+	if !reflect.DeepEqual(groups, map[string][]string{"": {"1 != 2"}}) {
+		t.Errorf("wrong groups: %q", groups)
+	}
+
+	groups, hasDiff := deep.CompareGrouped(a, a)
+	if groups != nil || hasDiff {
+		t.Error("should be equal:", groups)
+	}
+}
+
+func TestDurationTolerance(t *testing.T) {
+	type timing struct {
+		Elapsed time.Duration
+	}
+	a := timing{Elapsed: 100 * time.Millisecond}
+	b := timing{Elapsed: 105 * time.Millisecond}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.DurationTolerance = 10 * time.Millisecond
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(b, a, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Elapsed = 160 * time.Millisecond
+	opts.DurationTolerance = 50 * time.Millisecond
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Elapsed: 100ms != 160ms" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestMapMissingKeyEqualsZero(t *testing.T) {
+	a := map[string]int{"x": 0, "y": 1}
+	b := map[string]int{"y": 1, "z": 0}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.MapMissingKeyEqualsZero = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	// Non-zero values are still missing
+	a["x"] = 2
+	b["z"] = 3
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "x: 2 != [empty value]" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "z: [empty value] != 3" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	// Zero values in interfaces
+	c := map[string]interface{}{"s": "", "n": nil}
+	diff, _ = deep.CompareS(c, map[string]interface{}{}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestSortSlicesBeforeCompare(t *testing.T) {
+	opts := deep.DefaultOptions
+	opts.SortSlicesBeforeCompare = true
+
+	a := []int{3, 1, 2}
+	b := []int{1, 2, 3}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 3 {
+		t.Fatalf("expected 3 diffs, got %d: %s", len(diff), diff)
+	}
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	if a[0] != 3 {
+		t.Error("slice was modified:", a)
+	}
+
+	diff, _ = deep.CompareS([]string{"c", "a", "b"}, []string{"b", "d", "a", "c"}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "#3: [empty value] != d" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Other types need a SliceLess func
+	type user struct {
+		Name string
+	}
+	users1 := []user{{"foo"}, {"bar"}}
+	users2 := []user{{"bar"}, {"foo"}}
+	diff, _ = deep.CompareS(users1, users2, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	opts.SliceLess = map[reflect.Type]func(i, j interface{}) bool{
+		reflect.TypeOf(user{}): func(i, j interface{}) bool {
+			return i.(user).Name < j.(user).Name
+		},
+	}
+	diff, _ = deep.CompareS(users1, users2, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestLeafDiffsOnly(t *testing.T) {
+	type address struct {
+		City string
+		Zip  string
+	}
+	type person struct {
+		Name    string
+		Address *address
+		Tags    []string
+	}
+	a := person{
+		Name:    "foo",
+		Address: &address{City: "Paris"},
+		Tags:    []string{"x"},
+	}
+	b := person{
+		Name: "foo",
+		Tags: []string{"x", "y"},
+	}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Address: deep_test.address != <nil pointer>" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "Tags.#1: [empty value] != y" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	opts := deep.DefaultOptions
+	opts.LeafDiffsOnly = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Address.City: Paris != " {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "Tags.#1:  != y" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	// Missing values equal to zero have no diffs
+	a.Address.City = ""
+	b.Tags = []string{"x", ""}
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(map[string]int{"x": 1}, map[string]int(nil), opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "x: 1 != 0" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestCompareReaderContent(t *testing.T) {
+	type upload struct {
+		Name string
+		Body *bytes.Reader
+	}
+	opts := deep.DefaultOptions
+	opts.CompareReaderContent = true
+	opts.ReaderTypes = map[reflect.Type]bool{
+		reflect.TypeOf(&bytes.Reader{}): true,
+	}
+
+	a := upload{Name: "foo", Body: bytes.NewReader([]byte("hello"))}
+	b := upload{Name: "foo", Body: bytes.NewReader([]byte("hello"))}
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	// The readers were consumed
+	if a.Body.Len() != 0 {
+		t.Error("reader not read:", a.Body.Len())
+	}
+
+	a = upload{Name: "foo", Body: bytes.NewReader([]byte("hello"))}
+	b = upload{Name: "foo", Body: bytes.NewReader([]byte("world"))}
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Body: hello != world" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Types must be opted in
+	opts.ReaderTypes = nil
+	a = upload{Name: "foo", Body: bytes.NewReader([]byte("hello"))}
+	b = upload{Name: "foo", Body: bytes.NewReader([]byte("world"))}
+	deep.CompareS(a, b, opts)
+	if a.Body.Len() != 5 {
+		t.Error("reader was read:", a.Body.Len())
+	}
+}
+
+func TestStringIgnoreWhitespace(t *testing.T) {
+	type query struct {
+		SQL string
+	}
+	a := query{SQL: "SELECT *\n  FROM users\n WHERE id = 1"}
+	b := query{SQL: " SELECT * FROM users\tWHERE  id = 1\n"}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.StringIgnoreWhitespace = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.SQL = "SELECT * FROM users WHERE id = 2"
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "SQL: SELECT *\n  FROM users\n WHERE id = 1 != SELECT * FROM users WHERE id = 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestByFieldNameReordered(t *testing.T) {
+	type ab struct {
+		A int
+		B string
+	}
+	type ba struct {
+		B string
+		A int
+	}
+	opts := deep.DefaultOptions
+	opts.ByFieldName = true
+	diff, _ := deep.CompareS(ab{A: 1, B: "x"}, ba{B: "x", A: 1}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(ab{A: 1, B: "x"}, ba{B: "y", A: 2}, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "A: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "B: x != y" {
+		t.Error("wrong diff:", diff[1])
+	}
+}
+
+func TestMaxDiffUnlimited(t *testing.T) {
+	a := make([]int, 50)
+	b := make([]int, 50)
+	for i := range b {
+		b[i] = i + 1
+	}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != deep.DefaultOptions.MaxDiff {
+		t.Fatalf("expected %d diffs, got %d", deep.DefaultOptions.MaxDiff, len(diff))
+	}
+
+	opts := deep.DefaultOptions
+	opts.MaxDiff = 0
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 50 {
+		t.Fatalf("expected 50 diffs, got %d", len(diff))
+	}
+	if diff[49] != "#49: 0 != 50" {
+		t.Error("wrong diff:", diff[49])
+	}
+}
+
+func TestFloatExact(t *testing.T) {
+	x, y := 0.1, 0.2
+	a := x + y
+	b := 0.3
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.FloatExact = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "0.30000000000000004 != 0.3" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(0.3, 0.3, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(float32(0.5), float32(0.5), opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(math.NaN(), math.NaN(), opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(0.0, math.Copysign(0, -1), opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "0 != -0" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestCompareTrace(t *testing.T) {
+	type account struct {
+		Name     string
+		password string
+		Cache    map[string]int `compare:",skip"`
+		mu       sync.Mutex
+	}
+	a := &account{Name: "foo", password: "x", Cache: map[string]int{"a": 1}}
+	b := &account{Name: "bar", password: "y"}
+
+	diff, skips := deep.CompareTrace(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if skips != nil {
+		t.Error("skips traced without TraceSkips:", skips)
+	}
+
+	opts := deep.DefaultOptions
+	opts.TraceSkips = true
+	diff, skips = deep.CompareTrace(a, b, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Name: foo != bar" {
+		t.Error("wrong diff:", diff[0])
+	}
+	expect := []deep.Skip{
+		{Path: "password", Reason: "unexported"},
+		{Path: "Cache", Reason: "tag"},
+		{Path: "mu", Reason: "unexported"},
+	}
+	if !reflect.DeepEqual(skips, expect) {
+		t.Errorf("got %+v, expected %+v", skips, expect)
+	}
+
+	opts.IgnoreValue = func(path []string, a, b interface{}) bool {
+		return strings.Join(path, ".") == "Name"
+	}
+	diff, skips = deep.CompareTrace(a, b, opts)
+	if diff != nil {
+		t.Error("should be equal:", diff)
+	}
+	if len(skips) != 4 || skips[0] != (deep.Skip{Path: "Name", Reason: "ignored"}) {
+		t.Errorf("wrong skips: %+v", skips)
+	}
+}
+
+// Box is a generic type for TestGenericTypeNames.
+type Box[T any] struct {
+	Value T
+}
+
+func TestGenericTypeNames(t *testing.T) {
+	diff, _ := deep.CompareS(Box[int]{Value: 1}, Box[int]{Value: 2})
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Value: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(Box[int]{}, Box[Email]{})
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "deep_test.Box[int] != deep_test.Box[deep_test.Email]" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(Box[map[string]*Email]{}, Box[Box[Email]]{})
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "deep_test.Box[map[string]*deep_test.Email] != deep_test.Box[deep_test.Box[deep_test.Email]]" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestStringTrimPrefixesSuffixes(t *testing.T) {
+	type service struct {
+		Name string
+		Host string
+	}
+	a := service{Name: "prod-service-a", Host: "db.prod.internal"}
+	b := service{Name: "service-a", Host: "db"}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.StringTrimPrefixes = []string{"prod-", "staging-"}
+	opts.StringTrimSuffixes = []string{".internal", ".prod"}
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Name = "staging-service-b"
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Name: prod-service-a != staging-service-b" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestSortOutput(t *testing.T) {
+	type config struct {
+		Zone    string
+		Name    string
+		Limits  map[string]int
+		Address string
+	}
+	a := config{Zone: "a", Name: "foo", Limits: map[string]int{"mem": 1}, Address: "x"}
+	b := config{Zone: "b", Name: "bar", Limits: map[string]int{"mem": 2}, Address: "y"}
+
+	opts := deep.DefaultOptions
+	opts.SortOutput = true
+	diff, _ := deep.CompareS(a, b, opts)
+	expect := []string{
+		"Address: x != y",
+		"Limits.mem: 1 != 2",
+		"Name: foo != bar",
+		"Zone: a != b",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	patch, _ := deep.ComparePatch(a, b, opts)
+	if len(patch) != 4 || patch[0].Path != "Address" || patch[3].Path != "Zone" {
+		t.Errorf("patch not sorted: %+v", patch)
+	}
+}
+
+// profile hides its state behind getters for TestCompareViaGetters.
+type profile struct {
+	name string
+	age  int
+}
+
+func (p *profile) GetName() string              { return p.name }
+func (p *profile) GetAge() int                  { return p.age }
+func (p *profile) GetBroken() int               { panic("broken") }
+func (p *profile) GetOther(n int) int           { return n }
+func (p *profile) Describe() string             { return fmt.Sprintf("%s (%d)", p.name, p.age) }
+func (p *profile) SetName(name string)          { p.name = name }
+func (p *profile) GetNameAndAge() (string, int) { return p.name, p.age }
+
+func TestCompareViaGetters(t *testing.T) {
+	a := &profile{name: "foo", age: 30}
+	b := &profile{name: "bar", age: 31}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) > 0 {
+		t.Error("unexported fields compared:", diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.CompareViaGetters = true
+	opts.GetterTypes = map[reflect.Type]bool{
+		reflect.TypeOf(&profile{}): true,
+	}
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "GetAge: 30 != 31" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "GetName: foo != bar" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	b.name, b.age = "foo", 30
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func BenchmarkCompareEqualMaps(b *testing.B) {
+	x := make(map[string]int, 1000)
+	y := make(map[string]int, 1000)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		x[key] = i
+		y[key] = i
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		deep.CompareS(x, y)
+	}
+}
+
+func BenchmarkCompareEqualSlices(b *testing.B) {
+	x := make([]int, 1000)
+	y := make([]int, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		deep.CompareS(x, y)
+	}
+}
+
+func TestEqualSliceAllocs(t *testing.T) {
+	x := make([]int, 1000)
+	y := make([]int, 1000)
+	// Indexes are only formatted for diffs, so allocations don't grow with
+	// the number of equal elements.
+	allocs := testing.AllocsPerRun(10, func() {
+		deep.CompareS(x, y)
+	})
+	if allocs > 50 {
+		t.Errorf("%.0f allocations comparing equal slices", allocs)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	type record struct {
+		Name  string
+		Email string
+		Age   int
+		City  string
+	}
+	a := record{Name: "foo", Email: "foo@example.com", Age: 30, City: "Paris"}
+	b := record{Name: "foo", Email: "bar@example.com", Age: 31, City: "Paris"}
+	if s := deep.Similarity(a, a); s != 1 {
+		t.Errorf("got %v, expected 1", s)
+	}
+	if s := deep.Similarity(a, b); s != 0.5 {
+		t.Errorf("got %v, expected 0.5", s)
+	}
+	if s := deep.Similarity(a, record{}); s != 0 {
+		t.Errorf("got %v, expected 0", s)
+	}
+	if s := deep.Similarity(1, "1"); s != 0 {
+		t.Errorf("got %v, expected 0", s)
+	}
+	if s := deep.Similarity(nil, nil); s != 1 {
+		t.Errorf("got %v, expected 1", s)
+	}
+
+	// Not limited by MaxDiff
+	opts := deep.DefaultOptions
+	opts.MaxDiff = 1
+	if s := deep.Similarity(a, b, opts); s != 0.5 {
+		t.Errorf("got %v, expected 0.5", s)
+	}
+}
+
+func TestSimilarityShallow(t *testing.T) {
+	type record struct {
+		Name string
+		Tags []string `compare:",shallow"`
+	}
+	a := record{Name: "foo", Tags: []string{"x"}}
+	b := record{Name: "bar", Tags: []string{"x"}}
+	if s := deep.Similarity(a, b); s != 0.5 {
+		t.Errorf("got %v, expected 0.5", s)
+	}
+
+	// Nil pointers are equal leaves
+	type ptrs struct {
+		Name string
+		P    *int
+	}
+	if s := deep.Similarity(ptrs{Name: "foo"}, ptrs{Name: "bar"}); s != 0.5 {
+		t.Errorf("got %v, expected 0.5", s)
+	}
+}
+
+func TestSoftTypeMismatch(t *testing.T) {
+	type A string
+	type B string
+	type pair struct {
+		X interface{}
+		Y interface{}
+	}
+	diff, _ := deep.CompareS(A("foo"), B("foo"))
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.SoftTypeMismatch = true
+	diff, _ = deep.CompareS(A("foo"), B("foo"), opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(pair{X: A("foo"), Y: 1}, pair{X: B("bar"), Y: 1.5}, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "X: foo (deep_test.A) != bar (deep_test.B)" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "Y: 1 (int) != 1.5 (float64)" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	// Incompatible kinds are still a type mismatch
+	diff, _ = deep.CompareS(A("1"), 1, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "deep_test.A != int" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+// version compares itself by major version only for TestUseDiffable.
+type version struct {
+	Major, Minor int
+}
+
+func (v version) Diff(other interface{}) []string {
+	o := other.(version)
+	if v.Major != o.Major {
+		return []string{
+			fmt.Sprintf("major version %d != %d", v.Major, o.Major),
+			"incompatible",
+		}
+	}
+	return nil
+}
+
+func TestUseDiffable(t *testing.T) {
+	type release struct {
+		Name    string
+		Version version
+	}
+	a := release{Name: "foo", Version: version{Major: 1, Minor: 2}}
+	b := release{Name: "foo", Version: version{Major: 1, Minor: 3}}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.UseDiffable = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b.Version.Major = 2
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Version: major version 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "Version: incompatible" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	m, _ := deep.CompareM(a, b, opts)
+	if len(m) != 1 || m["Version"].NewValue != b.Version {
+		t.Errorf("wrong diffs: %+v", m)
+	}
+}
+
+func TestPathStyle(t *testing.T) {
+	type address struct {
+		Zip  string
+		Tags map[string]int
+	}
+	type user struct {
+		Addresses []address
+	}
+	a := user{Addresses: []address{{Zip: "75001", Tags: map[string]int{"env": 1, "a.b": 1, "2x": 1}}}}
+	b := user{Addresses: []address{{Zip: "69001", Tags: map[string]int{"env": 2, "a.b": 2, "2x": 2}}}}
+
+	opts := deep.DefaultOptions
+	opts.DeterministicMapOrder = true
+	diff, _ := deep.CompareS(a, b, opts)
+	expect := []string{
+		"Addresses.#0.Zip: 75001 != 69001",
+		"Addresses.#0.Tags.2x: 1 != 2",
+		`Addresses.#0.Tags.["a.b"]: 1 != 2`,
+		"Addresses.#0.Tags.env: 1 != 2",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	opts.PathStyle = deep.JQ
+	diff, _ = deep.CompareS(a, b, opts)
+	expect = []string{
+		".Addresses[0].Zip: 75001 != 69001",
+		`.Addresses[0].Tags["2x"]: 1 != 2`,
+		`.Addresses[0].Tags["a.b"]: 1 != 2`,
+		".Addresses[0].Tags.env: 1 != 2",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	// Option paths are still dotted
+	opts.Redact = []string{"Addresses.#0.Zip"}
+	m, _ := deep.CompareM(a, b, opts)
+	if _, ok := m[".Addresses[0].Zip"]; ok || len(m) != 3 {
+		t.Errorf("wrong diffs: %+v", m)
+	}
+}
+
+func TestJSONPointerPathStyle(t *testing.T) {
+	type address struct {
+		Zip  string
+		Tags map[string]int
+	}
+	type user struct {
+		Addresses []address
+	}
+	a := user{Addresses: []address{{Zip: "75001", Tags: map[string]int{"a/b": 1, "m~n": 1, "~/": 1}}}}
+	b := user{Addresses: []address{{Zip: "69001", Tags: map[string]int{"a/b": 2, "m~n": 2, "~/": 2}}}}
+
+	opts := deep.DefaultOptions
+	opts.DeterministicMapOrder = true
+	opts.PathStyle = deep.JSONPointer
+	diff, _ := deep.CompareS(a, b, opts)
+	expect := []string{
+		"/Addresses/0/Zip: 75001 != 69001",
+		"/Addresses/0/Tags/a~1b: 1 != 2",
+		"/Addresses/0/Tags/m~0n: 1 != 2",
+		"/Addresses/0/Tags/~0~1: 1 != 2",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	patch, _ := deep.ComparePatch(a, b, opts)
+	if len(patch) != 4 || patch[0].Path != "/Addresses/0/Zip" {
+		t.Errorf("wrong patch: %+v", patch)
+	}
+}
+
+func TestIgnoreTag(t *testing.T) {
+	type record struct {
+		Name      string
+		UpdatedAt time.Time `audit:"ignore"`
+		UpdatedBy string    `audit:""`
+		Version   int       `json:"version"`
+	}
+	a := record{Name: "foo", UpdatedAt: time.Now(), UpdatedBy: "alice", Version: 1}
+	b := record{Name: "foo", UpdatedAt: time.Now().Add(time.Hour), UpdatedBy: "bob", Version: 2}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 3 {
+		t.Fatalf("expected 3 diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.IgnoreTag = "audit"
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Version: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestIgnoreField(t *testing.T) {
+	type record struct {
+		Name      string
+		UpdatedAt int `compare:"updated_at"`
+		Attrs     map[string]int
+	}
+	a := record{Name: "foo", UpdatedAt: 1, Attrs: map[string]int{"UpdatedAt": 1}}
+	b := record{Name: "foo", UpdatedAt: 2, Attrs: map[string]int{"UpdatedAt": 2}}
+	opts := deep.DefaultOptions
+	opts.IgnoreField = func(f reflect.StructField) bool { return f.Name == "UpdatedAt" }
+	diff, _ := deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Attrs.UpdatedAt: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestDiffResultDelta(t *testing.T) {
+	type metrics struct {
+		Requests int
+		Errors   uint
+		Latency  float64
+		Name     string
+	}
+	a := metrics{Requests: 100, Errors: 5, Latency: 1.5, Name: "foo"}
+	b := metrics{Requests: 120, Errors: 2, Latency: 1.25, Name: "bar"}
+	m, _ := deep.CompareM(a, b)
+	if len(m) != 4 {
+		t.Fatalf("expected 4 diffs, got %d: %+v", len(m), m)
+	}
+	if d := m["Requests"].Delta; d != int64(20) {
+		t.Errorf("wrong delta: %#v", d)
+	}
+	if d := m["Errors"].Delta; d != int64(-3) {
+		t.Errorf("wrong delta: %#v", d)
+	}
+	if d := m["Latency"].Delta; d != -0.25 {
+		t.Errorf("wrong delta: %#v", d)
+	}
+	if d := m["Name"].Delta; d != nil {
+		t.Errorf("wrong delta: %#v", d)
+	}
+
+	// Durations, with or without DurationTolerance
+	type timing struct {
+		Elapsed time.Duration
+	}
+	opts := deep.DefaultOptions
+	for _, tolerance := range []time.Duration{0, time.Millisecond} {
+		opts.DurationTolerance = tolerance
+		m, _ = deep.CompareM(timing{time.Second}, timing{3 * time.Second}, opts)
+		if d, ok := m["Elapsed"].Delta.(time.Duration); !ok || d != 2*time.Second {
+			t.Errorf("tolerance %s: got delta %#v, expected 2s", tolerance, m["Elapsed"].Delta)
+		}
+	}
+}
+
+func TestCompareHTTPMaps(t *testing.T) {
+	a := http.Header{
+		"Accept":       {"text/html", "application/json"},
+		"Content-Type": {"text/plain"},
+	}
+	b := http.Header{
+		"accept":       {"application/json", "text/html"},
+		"content-type": {"text/plain"},
+	}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) == 0 {
+		t.Fatal("no diff")
+	}
+
+	opts := deep.DefaultOptions
+	opts.CompareHTTPMaps = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b["content-type"] = []string{"text/csv"}
+	b.Set("X-Request-Id", "1")
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Content-Type: [text/plain] != [text/csv]" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "X-Request-Id: [empty value] != [1]" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	// url.Values keys are case-sensitive
+	q1 := url.Values{"tag": {"a", "b"}, "Page": {"1"}}
+	q2 := url.Values{"tag": {"b", "a"}, "page": {"1"}}
+	diff, _ = deep.CompareS(q1, q2, opts)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Page: [1] != [empty value]" {
+		t.Error("wrong diff:", diff[0])
+	}
+	if diff[1] != "page: [empty value] != [1]" {
+		t.Error("wrong diff:", diff[1])
+	}
+
+	// Keys without values are keys
+	diff, _ = deep.CompareS(http.Header{"X": {}}, http.Header{}, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "X: [] != [empty value]" {
+		t.Error("wrong diff:", diff[0])
+	}
+	diff, _ = deep.CompareS(http.Header{"X": {}}, http.Header{"X": {"a"}}, opts)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "X: [] != [a]" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+// statusA and statusB are duplicate enums for TestEnumByName.
+type statusA int
+
+const (
+	statusAUnknown statusA = iota
+	statusAActive
+)
+
+func (s statusA) String() string {
+	return [...]string{"unknown", "active"}[s]
+}
+
+type statusB int
+
+const (
+	statusBActive statusB = iota + 1
+	statusBUnknown
+)
+
+func (s statusB) String() string {
+	return [...]string{"", "active", "unknown"}[s]
+}
+
+func TestEnumByName(t *testing.T) {
+	type v1 struct {
+		Status statusA
+	}
+	type v2 struct {
+		Status statusB
+	}
+	diff, _ := deep.CompareS(statusAActive, statusBActive)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.EnumByName = true
+	diff, _ = deep.CompareS(statusAActive, statusBActive, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	opts.ByFieldName = true
+	diff, _ = deep.CompareS(v1{Status: statusAActive}, v2{Status: statusBUnknown}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Status: active != unknown" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(statusAUnknown, statusAActive, opts)
+	if len(diff) != 1 || diff[0] != "unknown != active" {
+		t.Error("wrong diff:", diff)
+	}
+}
+
+func TestMaxDiffPerPath(t *testing.T) {
+	type data struct {
+		A    []int
+		B    map[string]int
+		Name string
+	}
+	a := data{A: make([]int, 20), B: map[string]int{}, Name: "foo"}
+	b := data{A: make([]int, 20), B: map[string]int{}, Name: "bar"}
+	for i := 0; i < 20; i++ {
+		b.A[i] = i + 1
+		a.B[fmt.Sprintf("k%02d", i)] = i
+	}
+	opts := deep.DefaultOptions
+	opts.DeterministicMapOrder = true
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) != 10 || !strings.HasPrefix(diff[9], "A.") {
+		t.Fatalf("expected 10 diffs in A, got %d: %s", len(diff), diff)
+	}
+
+	opts.MaxDiffPerPath = 3
+	diff, _ = deep.CompareS(a, b, opts)
+	expect := []string{
+		"A.#0: 0 != 1",
+		"A.#1: 0 != 2",
+		"A.#2: 0 != 3",
+		"B.k00: 0 != [empty value]",
+		"B.k01: 1 != [empty value]",
+		"B.k02: 2 != [empty value]",
+		"Name: foo != bar",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	// Nested containers have their own maximum
+	c := [][]int{{1, 1, 1, 1}, {1, 1, 1, 1}}
+	d := [][]int{{2, 2, 2, 2}, {2, 2, 2, 2}}
+	diff, _ = deep.CompareS(c, d, opts)
+	if len(diff) != 6 {
+		t.Errorf("expected 6 diffs, got %d: %s", len(diff), diff)
+	}
+}
+
+func TestCompareSchema(t *testing.T) {
+	type address struct {
+		City string
+		Zip  interface{}
+	}
+	type user struct {
+		Name    string
+		Age     interface{}
+		Tags    []string
+		Address *address
+		Extra   map[string]interface{}
+	}
+	schema := user{
+		Name:    "",
+		Age:     0,
+		Tags:    []string{""},
+		Address: &address{Zip: ""},
+		Extra:   map[string]interface{}{"score": 0.0},
+	}
+	b := user{
+		Name:    "foo",
+		Age:     30,
+		Tags:    []string{"x", "y"},
+		Address: &address{City: "Paris", Zip: "75001"},
+		Extra:   map[string]interface{}{"score": 1.5, "other": true},
+	}
+	diff, _ := deep.CompareSchema(schema, b)
+	if len(diff) > 0 {
+		t.Error("should conform:", diff)
+	}
+
+	b.Age = "30"
+	b.Address.Zip = 75001
+	b.Extra["score"] = "high"
+	diff, _ = deep.CompareSchema(schema, b)
+	expect := []string{
+		"Age: type changed int -> string",
+		"Address.Zip: type changed string -> int",
+		`Extra.score: type float64 -> string (0 -> "high")`,
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	// Nil pointers conform in either order, like missing map keys
+	type ptr struct {
+		P *int
+	}
+	x := 1
+	diff, _ = deep.CompareSchema(ptr{&x}, ptr{nil})
+	if len(diff) > 0 {
+		t.Error("should conform:", diff)
+	}
+	diff, _ = deep.CompareSchema(ptr{nil}, ptr{&x})
+	if len(diff) > 0 {
+		t.Error("should conform:", diff)
+	}
+}
+
+func TestIgnoreBothZero(t *testing.T) {
+	a := map[string]interface{}{"count": 0, "name": "foo", "tags": []string(nil)}
+	b := map[string]interface{}{"name": "foo", "extra": ""}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 3 {
+		t.Fatalf("expected 3 diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.IgnoreBothZero = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b["count"] = 1
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "count: 0 != 1" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// A nil pointer and a pointer to zero
+	type ptr struct {
+		P *int
+	}
+	zero, one := 0, 1
+	diff, _ = deep.CompareS(ptr{nil}, ptr{&zero}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(ptr{&zero}, ptr{nil}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(ptr{nil}, ptr{&one}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "P: <nil pointer> != int" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+// shape is implemented by circle and square for TestCompareAsInterface.
+type shape interface {
+	Name() string
+	Area() float64
+	Scale(f float64)
+}
+
+type circle struct {
+	R float64
+}
+
+func (c *circle) Name() string    { return "unit" }
+func (c *circle) Area() float64   { return 3 * c.R * c.R }
+func (c *circle) Scale(f float64) { c.R *= f }
+
+type square struct {
+	Side float64
+}
+
+func (s *square) Name() string    { return "unit" }
+func (s *square) Area() float64   { return s.Side * s.Side }
+func (s *square) Scale(f float64) { s.Side *= f }
+
+func TestCompareAsInterface(t *testing.T) {
+	type drawing struct {
+		Shape shape
+	}
+	a := drawing{Shape: &circle{R: 1}}
+	b := drawing{Shape: &square{Side: 1}}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 {
+		t.Fatalf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+	if diff[0] != "Shape: variant *deep_test.circle -> *deep_test.square" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.CompareAsInterface = []reflect.Type{reflect.TypeOf((*shape)(nil)).Elem()}
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Shape.Area: 3 != 1" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	b.Shape = &square{Side: math.Sqrt(3)}
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestDumpOnMismatch(t *testing.T) {
+	type config struct {
+		Name string
+		Port int
+	}
+	a := config{Name: "foo", Port: 80}
+	b := map[string]interface{}{"name": "foo", "port": 80}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 1 || diff[0] != "deep_test.config != map[string]interface {}" {
+		t.Fatal("wrong diff:", diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.DumpOnMismatch = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	expect := `deep_test.config {
+  "Name": "foo",
+  "Port": 80
+} != map[string]interface {} {
+  "name": "foo",
+  "port": 80
+}`
+	if diff[0] != expect {
+		t.Errorf("wrong diff:\n%s", diff[0])
+	}
+
+	opts.ValueFormatter = func(v interface{}) string {
+		return fmt.Sprintf("%#v", v)
+	}
+	diff, _ = deep.CompareS(a, 1, opts)
+	if len(diff) != 1 || diff[0] != `deep_test.config deep_test.config{Name:"foo", Port:80} != int 1` {
+		t.Error("wrong diff:", diff)
+	}
+
+	// Nested type mismatches aren't dumped
+	diff, _ = deep.CompareS([]interface{}{a}, []interface{}{b}, opts)
+	if len(diff) != 1 || diff[0] != "#0: type changed deep_test.config -> map[string]interface {}" {
+		t.Error("wrong diff:", diff)
+	}
+}
+
+func TestNumericNoiseFloor(t *testing.T) {
+	type metrics struct {
+		Count   int
+		Bytes   uint64
+		Latency float64
+	}
+	a := metrics{Count: 100, Bytes: 1000, Latency: 1.50}
+	b := metrics{Count: 102, Bytes: 997, Latency: 1.53}
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 3 {
+		t.Fatalf("expected 3 diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.NumericNoiseFloor = 5
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	b = metrics{Count: 110, Bytes: 990, Latency: 7.5}
+	diff, _ = deep.CompareS(a, b, opts)
+	expect := []string{
+		"Count: 100 != 110",
+		"Bytes: 1000 != 990",
+		"Latency: 1.5 != 7.5",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	// The floor is exclusive
+	diff, _ = deep.CompareS(10, 15, opts)
+	if len(diff) != 1 {
+		t.Errorf("expected 1 diff, got %d: %s", len(diff), diff)
+	}
+}
+
+func TestFirstDiff(t *testing.T) {
+	type address struct {
+		City string
+	}
+	type user struct {
+		Name    string
+		Address address
+		Age     int
+	}
+	a := user{Name: "foo", Address: address{City: "Paris"}, Age: 30}
+	b := user{Name: "foo", Address: address{City: "Lyon"}, Age: 31}
+	path, old, new, found := deep.FirstDiff(a, b)
+	if !found {
+		t.Fatal("no diff")
+	}
+	if path != "Address.City" || old != "Paris" || new != "Lyon" {
+		t.Errorf("wrong diff: %s: %v != %v", path, old, new)
+	}
+
+	path, old, new, found = deep.FirstDiff(1, 2)
+	if !found || path != "" || old != int64(1) || new != int64(2) {
+		t.Errorf("wrong diff: %s: %v != %v", path, old, new)
+	}
+
+	if _, _, _, found = deep.FirstDiff(a, a); found {
+		t.Error("should be equal")
+	}
+}
+
+func TestNilPointerSliceElements(t *testing.T) {
+	type item struct {
+		ID   int
+		Name string
+	}
+	a := []*item{{1, "foo"}, {2, "bar"}, {3, "baz"}}
+	b := []*item{{1, "foo"}, {2, "bar"}, nil}
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "#2: {3 baz} != <nil>" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(b, a)
+	if len(diff) != 1 || diff[0] != "#2: <nil> != {3 baz}" {
+		t.Error("wrong diff:", diff)
+	}
+
+	m, _ := deep.CompareM(b, a)
+	if m["#2"].NewValue != (item{3, "baz"}) || m["#2"].OldValue != "<nil>" {
+		t.Errorf("wrong diff: %+v", m)
+	}
+}
+
+func TestCompareTypeCounts(t *testing.T) {
+	type address struct {
+		City, Zip string
+	}
+	type user struct {
+		Name    string
+		Email   string
+		Age     int
+		Address address
+		Tags    []string
+	}
+	a := user{Name: "foo", Email: "a", Age: 1, Address: address{City: "x", Zip: "1"}, Tags: []string{"a"}}
+	b := user{Name: "bar", Email: "b", Age: 2, Address: address{City: "y", Zip: "1"}, Tags: []string{"b"}}
+	counts, _ := deep.CompareTypeCounts(a, b)
+	expect := map[reflect.Type]int{
+		reflect.TypeOf(user{}):    4,
+		reflect.TypeOf(address{}): 1,
+	}
+	if !reflect.DeepEqual(counts, expect) {
+		t.Errorf("got %v, expected %v", counts, expect)
+	}
+
+	counts, hasDiff := deep.CompareTypeCounts(a, a)
+	if counts != nil || hasDiff {
+		t.Error("should be equal:", counts)
+	}
+}
+
+func TestArraySliceInterchangeable(t *testing.T) {
+	a := [3]int{1, 2, 3}
+	b := []int{1, 2, 3}
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+
+	opts := deep.DefaultOptions
+	opts.ArraySliceInterchangeable = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(a, []int{1, 2}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "#2: 3 != [empty value]" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestGraphEqual(t *testing.T) {
+	type node struct {
+		Val         int
+		Left, Right *node
+	}
+	shared := &node{Val: 1}
+	dag := &node{Left: shared, Right: shared}
+	tree := &node{Left: &node{Val: 1}, Right: &node{Val: 1}}
+
+	opts := deep.DefaultOptions
+	opts.GraphEqual = true
+	diff, _ := deep.CompareS(dag, tree, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	// The shared node is compared once, not once per pointer to it
+	var entered []string
+	onEnter := func(path []string) { entered = append(entered, strings.Join(path, ".")) }
+	for _, graphEqual := range []bool{false, true} {
+		entered = nil
+		o := deep.DefaultOptions
+		o.GraphEqual = graphEqual
+		o.OnEnter = onEnter
+		deep.CompareS(dag, &node{Left: shared, Right: shared}, o)
+		expect := []string{"Val", "Left", "Left.Val", "Left.Left", "Left.Right", "Right"}
+		if !graphEqual {
+			expect = append(expect, "Right.Val", "Right.Left", "Right.Right")
+		}
+		if !reflect.DeepEqual(entered, expect) {
+			t.Errorf("GraphEqual %t: got %q, expected %q", graphEqual, entered, expect)
+		}
+	}
+
+	tree.Right.Val = 2
+	diff, _ = deep.CompareS(dag, tree, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Right.Val: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Cycles
+	a := &node{Val: 1}
+	a.Left = a
+	b := &node{Val: 1}
+	b.Left = b
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	entered = nil
+	cycleOpts := opts
+	cycleOpts.OnEnter = onEnter
+	deep.CompareS(a, b, cycleOpts)
+	if expect := []string{"Val", "Left", "Right"}; !reflect.DeepEqual(entered, expect) {
+		t.Errorf("got %q, expected %q", entered, expect)
+	}
+	b.Val = 2
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Val: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestFormatTestify(t *testing.T) {
+	type T struct {
+		Name string
+		Age  int
+	}
+	diff, _ := deep.CompareS(T{"foo", 1}, T{"bar", 2})
+	got := deep.FormatTestify(diff)
+	expect := "Diff:\n" +
+		"--- Expected\n" +
+		"+++ Actual\n" +
+		"@@ Name @@\n" +
+		"-foo\n" +
+		"+bar\n" +
+		"@@ Age @@\n" +
+		"-1\n" +
+		"+2\n"
+	if got != expect {
+		t.Errorf("got %q, expected %q", got, expect)
+	}
+
+	if got := deep.FormatTestify(nil); got != "" {
+		t.Errorf("got %q, expected empty string", got)
+	}
+}
+
+// point implements fmt.GoStringer for TestPreferGoString.
+type point struct {
+	X, Y int
+}
+
+func (p point) GoString() string {
+	return fmt.Sprintf("point{X: %d, Y: %d}", p.X, p.Y)
+}
+
+func TestPreferGoString(t *testing.T) {
+	type T struct {
+		P point
+	}
+	a := T{P: point{X: 1, Y: 2}}
+	b := T{P: point{X: 1, Y: 3}}
+
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "P.Y: 2 != 3" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.PreferGoString = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "P: point{X: 1, Y: 2} != point{X: 1, Y: 3}" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(a, a, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+// myError is an error with a pointer receiver for TestTypedNilEqualsNil.
+type myError struct {
+	msg string
+}
+
+func (e *myError) Error() string {
+	return e.msg
+}
+
+func TestTypedNilEqualsNil(t *testing.T) {
+	type T struct {
+		Err error
+	}
+	var typedNil *myError
+	a := T{Err: nil}
+	b := T{Err: typedNil}
+
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.TypedNilEqualsNil = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(b, a, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	var err error = typedNil
+	diff, _ = deep.CompareS(nil, err, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	// A non-nil error still differs
+	diff, _ = deep.CompareS(a, T{Err: &myError{"foo"}}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+}
+
+func TestDiffRank(t *testing.T) {
+	type T struct {
+		Name   string
+		Note   string
+		Status string
+		Amount int
+	}
+	a := T{Name: "a", Note: "a", Status: "open", Amount: 1}
+	b := T{Name: "b", Note: "b", Status: "closed", Amount: 2}
+
+	opts := deep.DefaultOptions
+	opts.DiffRank = func(path []string) int {
+		switch path[len(path)-1] {
+		case "Amount":
+			return 2
+		case "Status":
+			return 1
+		}
+		return 0
+	}
+	diff, _ := deep.CompareS(a, b, opts)
+	expect := []string{
+		"Amount: 1 != 2",
+		"Status: open != closed",
+		"Name: a != b",
+		"Note: a != b",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	// Same rank diffs are sorted by SortOutput
+	opts.DiffRank = func(path []string) int {
+		if path[len(path)-1] == "Status" {
+			return 1
+		}
+		return 0
+	}
+	opts.SortOutput = true
+	diff, _ = deep.CompareS(a, b, opts)
+	expect = []string{
+		"Status: open != closed",
+		"Amount: 1 != 2",
+		"Name: a != b",
+		"Note: a != b",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+}
+
+func TestCompareYAMLValues(t *testing.T) {
+	// As decoded by gopkg.in/yaml.v2
+	a := map[interface{}]interface{}{
+		"name":  "app",
+		"port":  8080,
+		"ratio": 0.5,
+		"codes": map[interface{}]interface{}{
+			1:    "one",
+			"1":  "string one",
+			true: "yes",
+		},
+		"servers": []interface{}{
+			map[interface{}]interface{}{"host": "a", "weight": 1},
+		},
+		"tags": []interface{}{},
+	}
+	b := map[interface{}]interface{}{
+		"name":  "app",
+		"port":  uint64(8080),
+		"ratio": 0.5,
+		"codes": map[interface{}]interface{}{
+			1:    "one",
+			"1":  "string one",
+			true: "yes",
+		},
+		"servers": []interface{}{
+			map[interface{}]interface{}{"host": "a", "weight": 1.0},
+		},
+		"tags": []interface{}(nil),
+	}
+	diff, _ := deep.CompareYAMLValues(a, b)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	codes := b["codes"].(map[interface{}]interface{})
+	codes[1] = "uno"
+	codes["1"] = "string uno"
+	codes[2] = "two"
+	b["servers"].([]interface{})[0].(map[interface{}]interface{})["host"] = "b"
+	diff, _ = deep.CompareYAMLValues(a, b)
+	expect := []string{
+		"codes.1: one != uno",
+		"codes.1: string one != string uno",
+		"codes.2: [empty value] != two",
+		"servers.#0.host: a != b",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+}
+
+func TestMaxMapKeys(t *testing.T) {
+	a := make(map[int]int, 100000)
+	b := make(map[int]int, 100000)
+	for i := 0; i < 100000; i++ {
+		a[i] = i
+		b[i] = i + 1
+	}
+
+	values := 0
+	opts := deep.DefaultOptions
+	opts.MaxDiff = 0
+	opts.MaxMapKeys = 10
+	opts.OnEnter = func(path []string) { values++ }
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) != 10 {
+		t.Fatalf("got %d diffs, expected 10", len(diff))
+	}
+	if values != 10 {
+		t.Errorf("compared %d values, expected 10", values)
+	}
+	// The smallest keys by value, not by name
+	if diff[0] != "0: 0 != 1" || diff[9] != "9: 9 != 10" {
+		t.Errorf("wrong diffs: %q", diff)
+	}
+
+	// Same keys every time
+	again, _ := deep.CompareS(a, b, opts)
+	if !reflect.DeepEqual(again, diff) {
+		t.Errorf("got %q, expected %q", again, diff)
+	}
+
+	// Keys aren't formatted or sorted beyond MaxMapKeys, so allocations
+	// don't grow with the size of the map.
+	opts.OnEnter = nil
+	allocs := testing.AllocsPerRun(10, func() {
+		deep.CompareS(a, b, opts)
+	})
+	if allocs > 1000 {
+		t.Errorf("got %v allocs, expected at most 1000", allocs)
+	}
+}
+
+func BenchmarkMaxMapKeys(b *testing.B) {
+	x := make(map[int]int, 100000)
+	y := make(map[int]int, 100000)
+	for i := 0; i < 100000; i++ {
+		x[i] = i
+		y[i] = i + 1
+	}
+	opts := deep.DefaultOptions
+	opts.MaxMapKeys = 10
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		deep.CompareS(x, y, opts)
+	}
+}
+
+// decimal is a fixed-point number, Unscaled * 10^-Scale, like
+// decimal.Decimal, with a comparator registered in init.
+type decimal struct {
+	Unscaled int64
+	Scale    int
+}
+
+func (d decimal) rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(d.Unscaled), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.Scale)), nil))
+}
+
+// money is compared with == by RegisterType.
+type money struct {
+	Amount   int
+	Currency string
+}
+
+func init() {
+	deep.RegisterComparator(reflect.TypeOf(decimal{}), func(a, b interface{}) bool {
+		return a.(decimal).rat().Cmp(b.(decimal).rat()) == 0
+	})
+	deep.RegisterType(reflect.TypeOf(money{}))
+}
+
+func TestRegisterComparator(t *testing.T) {
+	type T struct {
+		Price decimal
+	}
+	diff, _ := deep.CompareS(T{decimal{10, 1}}, T{decimal{1, 0}})
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(T{decimal{10, 1}}, T{decimal{2, 0}})
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Price: {10 1} != {2 0}" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(money{1, "USD"}, money{1, "EUR"})
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "{1 USD} != {1 EUR}" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Options.Comparators take precedence
+	opts := deep.DefaultOptions
+	opts.Comparators = map[reflect.Type]func(a, b interface{}) bool{
+		reflect.TypeOf(decimal{}): func(a, b interface{}) bool { return true },
+	}
+	diff, _ = deep.CompareS(T{decimal{10, 1}}, T{decimal{2, 0}}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	type T struct {
+		F func(string) string
+	}
+
+	diff, _ := deep.CompareS(T{}, T{})
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(T{strings.ToUpper}, T{strings.ToUpper})
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, _ = deep.CompareS(T{strings.ToUpper}, T{})
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "F: <func> != <nil func>" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(T{}, T{strings.ToUpper})
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "F: <nil func> != <func>" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(T{strings.ToUpper}, T{strings.ToLower})
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "F: strings.ToUpper != strings.ToLower" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestAnnotateTypes(t *testing.T) {
+	type T struct {
+		Name string
+		Age  int
+		Any  interface{}
+	}
+	opts := deep.DefaultOptions
+	opts.AnnotateTypes = true
+	diff, _ := deep.CompareS(T{"foo", 10, 1.5}, T{"bar", 11, 2.5}, opts)
+	expect := []string{
+		"Name (string): foo != bar",
+		"Age (int): 10 != 11",
+		"Any (float64): 1.5 != 2.5",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	diff, _ = deep.CompareS(1, 2, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "(int) 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestInterfaceTypeChanged(t *testing.T) {
+	type T struct {
+		Value interface{}
+	}
+	diff, _ := deep.CompareS(T{1}, T{"1"})
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Value: type changed int -> string" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Same type, different values
+	diff, _ = deep.CompareS(T{1}, T{2})
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Value: 1 != 2" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Options that compare different types still apply
+	opts := deep.DefaultOptions
+	opts.NumericKindInsensitive = true
+	diff, _ = deep.CompareS(T{1}, T{1.0}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestMapKeyNormalize(t *testing.T) {
+	a := map[string]int{"Foo ": 1, "BAR": 2, "baz": 3}
+	b := map[string]int{"foo": 1, " bar": 2, "qux": 4}
+
+	opts := deep.DefaultOptions
+	opts.DeterministicMapOrder = true
+	opts.MapKeyNormalize = func(key interface{}) interface{} {
+		return strings.ToLower(strings.TrimSpace(key.(string)))
+	}
+	diff, _ := deep.CompareS(a, b, opts)
+	expect := []string{
+		"baz: 3 != [empty value]",
+		"qux: [empty value] != 4",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	b["BAZ"] = 30
+	delete(b, "qux")
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "baz: 3 != 30" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	b["BAZ"] = 3
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestTimeTruncate(t *testing.T) {
+	type T struct {
+		At time.Time
+	}
+	t1 := time.Date(2024, 5, 1, 12, 30, 15, 100, time.UTC)
+	t2 := time.Date(2024, 5, 1, 12, 30, 15, 999999999, time.UTC)
+
+	diff, _ := deep.CompareS(T{t1}, T{t2})
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+
+	opts := deep.DefaultOptions
+	opts.TimeTruncate = time.Second
+	diff, _ = deep.CompareS(T{t1}, T{t2}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	t3 := time.Date(2024, 5, 1, 12, 30, 16, 0, time.UTC)
+	diff, _ = deep.CompareS(T{t1}, T{t3}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "At: 2024-05-01 12:30:15.0000001 +0000 UTC != 2024-05-01 12:30:16 +0000 UTC" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestJSONValues(t *testing.T) {
+	type address struct {
+		City string
+		Zip  string `json:"zip"`
+	}
+	type T struct {
+		Name    string
+		Address address
+		Tags    []string
+	}
+	a := T{Name: "foo", Address: address{City: "a", Zip: "1"}, Tags: []string{"x"}}
+	b := T{Name: "bar", Address: address{City: "b", Zip: "1"}}
+
+	opts := deep.DefaultOptions
+	opts.JSONValues = true
+	diff, _ := deep.CompareS(a, b, opts)
+	expect := []string{
+		`Name: "foo" != "bar"`,
+		`Address.City: "a" != "b"`,
+		`Tags: ["x"] != [empty value]`,
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	// Struct-valued diff
+	diff, _ = deep.CompareS([]address{{City: "a"}}, []address{}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != `#0: {"City":"a","zip":""} != [empty value]` {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Values that can't be marshaled
+	diff, _ = deep.CompareS(map[string]interface{}{"c": make(chan int)}, map[string]interface{}{}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if !strings.HasPrefix(diff[0], "c: 0x") {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestContains(t *testing.T) {
+	diff, _ := deep.CompareS("connection refused: dial tcp", deep.Contains("refused"))
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	type entry struct {
+		Level string
+		Msg   interface{}
+	}
+	diff, _ = deep.CompareS(
+		entry{Level: "error", Msg: "connection refused"},
+		entry{Level: "error", Msg: deep.Contains("timeout")},
+	)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != `Msg: connection refused != Contains("timeout")` {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	a := map[string]interface{}{"msg": "user 42 logged in"}
+	b := map[string]interface{}{"msg": deep.Contains("logged in")}
+	diff, _ = deep.CompareS(a, b)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestUnwrapSingleField(t *testing.T) {
+	type celsius struct {
+		V float64
+	}
+	type userID struct {
+		id int
+	}
+	type reading struct {
+		Temp celsius
+		User userID
+	}
+	a := reading{Temp: celsius{20}, User: userID{1}}
+	b := reading{Temp: celsius{21}, User: userID{2}}
+
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Temp.V: 20 != 21" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.UnwrapSingleField = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Temp: 20 != 21" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts.CompareUnexportedFields = true
+	diff, _ = deep.CompareS(a, b, opts)
+	expect := []string{
+		"Temp: 20 != 21",
+		"User: 1 != 2",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+}
+
+func TestCompareSliceCapacity(t *testing.T) {
+	type T struct {
+		Buf []byte
+	}
+	buf := make([]byte, 2, 8)
+	a := T{Buf: buf[:2:4]}
+	b := T{Buf: buf}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.CompareSliceCapacity = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Buf: cap=4 != cap=8" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Elements are compared too
+	b.Buf = make([]byte, 2, 16)
+	copy(b.Buf, "xy")
+	diff, _ = deep.CompareS(a, b, opts)
+	expect := []string{
+		"Buf: cap=4 != cap=16",
+		"Buf.#0: 0 != 120",
+		"Buf.#1: 0 != 121",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	diff, _ = deep.CompareS(a, T{Buf: make([]byte, 2, 4)}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func TestAutoDerefMixedMaps(t *testing.T) {
+	one, two := 1, 2
+	a := map[string]*int{"a": &one, "b": &two}
+	b := map[string]int{"a": 1, "b": 2}
+
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "map[string]*int != map[string]int" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	opts := deep.DefaultOptions
+	opts.AutoDerefMixed = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(b, a, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	type T struct {
+		Counts interface{}
+	}
+	b["b"] = 3
+	b["c"] = 4
+	opts.DeterministicMapOrder = true
+	diff, _ = deep.CompareS(T{a}, T{b}, opts)
+	expect := []string{
+		"Counts.b: 2 != 3",
+		"Counts.c: [empty value] != 4",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+}
+
+func TestDiffResultTypePath(t *testing.T) {
+	type address struct {
+		City string
+		Zip  interface{}
+	}
+	type user struct {
+		Name    string
+		Address *address
+		Tags    map[string][]int
+	}
+	a := user{Address: &address{City: "a", Zip: 1}, Tags: map[string][]int{"x": {1}}}
+	b := user{Address: &address{City: "b", Zip: "1"}, Tags: map[string][]int{"x": {2}}}
+	diffs, _ := deep.CompareM(a, b)
+
+	userType := reflect.TypeOf(user{})
+	addrType := reflect.TypeOf(address{})
+	stringType := reflect.TypeOf("")
+	intType := reflect.TypeOf(0)
+	expect := map[string][]reflect.Type{
+		"Address.City": {userType, addrType, stringType},
+		"Address.Zip":  {userType, addrType, intType},
+		"Tags.x.#0":    {userType, reflect.TypeOf(map[string][]int{}), reflect.TypeOf([]int{}), intType},
+	}
+	if len(diffs) != len(expect) {
+		t.Fatalf("got %d diffs, expected %d: %v", len(diffs), len(expect), diffs)
+	}
+	for path, types := range expect {
+		if got := diffs[path].TypePath; !reflect.DeepEqual(got, types) {
+			t.Errorf("%s: got %v, expected %v", path, got, types)
+		}
+	}
+
+	diffs, _ = deep.CompareM(1, 2)
+	if got := diffs["result"].TypePath; !reflect.DeepEqual(got, []reflect.Type{intType}) {
+		t.Errorf("got %v, expected [int]", got)
+	}
+}
+
+func TestCompareJSONToStruct(t *testing.T) {
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	type response struct {
+		Items []item `json:"items"`
+		Total int    `json:"total"`
+	}
+	want := response{Items: []item{{1, "foo"}}, Total: 1}
+
+	diff, hasDiff, err := deep.CompareJSONToStruct([]byte(`{"items": [{"id": 1, "name": "foo"}], "total": 1}`), want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasDiff || len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	diff, hasDiff, err = deep.CompareJSONToStruct([]byte(`{"items": [{"id": 1, "name": "bar"}], "total": 2}`), &want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"Items.#0.Name: bar != foo",
+		"Total: 2 != 1",
+	}
+	if !hasDiff || !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+
+	_, _, err = deep.CompareJSONToStruct([]byte(`{"total": "one"}`), want)
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Errorf("got error %v, expected a *json.UnmarshalTypeError", err)
+	}
+}
+
+func TestFastByteSlices(t *testing.T) {
+	type T struct {
+		Data []byte
+	}
+	a := T{Data: []byte{1, 2, 3, 4}}
+	b := T{Data: []byte{1, 9, 3, 8}}
+
+	opts := deep.DefaultOptions
+	opts.FastByteSlices = true
+	diff, _ := deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Data.#1: 2 != 9" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(a, T{Data: []byte{1, 2}}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Data: len=4 != len=2" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	diff, _ = deep.CompareS(a, T{Data: []byte{1, 2, 3, 4}}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+}
+
+func BenchmarkCompareEqualBytes(b *testing.B) {
+	x := make([]byte, 1<<20)
+	y := make([]byte, 1<<20)
+	opts := deep.DefaultOptions
+	opts.FastByteSlices = true
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		deep.CompareS(x, y, opts)
+	}
+}
+
+func TestMaxSliceElems(t *testing.T) {
+	a := make([]int, 1000000)
+	b := make([]int, 1000001)
+	for i := range b {
+		b[i] = 1
+	}
+
+	values := 0
+	opts := deep.DefaultOptions
+	opts.MaxDiff = 0
+	opts.MaxSliceElems = 10
+	opts.OnEnter = func(path []string) { values++ }
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) != 10 {
+		t.Fatalf("got %d diffs, expected 10", len(diff))
+	}
+	if values != 10 {
+		t.Errorf("compared %d values, expected 10", values)
+	}
+	if diff[0] != "#0: 0 != 1" || diff[9] != "#9: 0 != 1" {
+		t.Errorf("wrong diffs: %q", diff)
+	}
+
+	values = 0
+	diff, _ = deep.CompareS([20]int{}, [20]int{}, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	if values != 10 {
+		t.Errorf("compared %d values, expected 10", values)
+	}
+}
+
+func TestDivergencePoint(t *testing.T) {
+	type address struct {
+		Street string
+		City   string
+		Geo    [2]float64
+	}
+	type user struct {
+		Name    string
+		Address address
+	}
+	type doc struct {
+		User user
+		Tags []string
+	}
+	a := doc{User: user{Name: "foo", Address: address{Street: "a", City: "b", Geo: [2]float64{1, 2}}}}
+	b := a
+	b.User.Address = address{Street: "x", City: "y", Geo: [2]float64{3, 2}}
+
+	if got := deep.DivergencePoint(a, b); got != "User.Address" {
+		t.Errorf("got %q, expected User.Address", got)
+	}
+
+	// One diff
+	b.User.Address = a.User.Address
+	b.User.Address.Geo[0] = 3
+	if got := deep.DivergencePoint(a, b); got != "User.Address.Geo.#0" {
+		t.Errorf("got %q, expected User.Address.Geo.#0", got)
+	}
+
+	// Diverged at the root
+	b.Tags = []string{"x"}
+	if got := deep.DivergencePoint(a, b); got != "" {
+		t.Errorf("got %q, expected empty string", got)
+	}
+
+	if got := deep.DivergencePoint(a, a); got != "" {
+		t.Errorf("got %q, expected empty string", got)
+	}
+}
+
+func TestPathRulesCaseInsensitive(t *testing.T) {
+	type price struct {
+		Amount   int
+		Currency string
+		Note     string
+	}
+	type order struct {
+		Price price
+	}
+	a := order{Price: price{Amount: 1, Currency: "usd", Note: "paid"}}
+	b := order{Price: price{Amount: 1, Currency: "USD", Note: "PAID"}}
+
+	diff, _ := deep.CompareS(a, b)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 diffs, got %d: %s", len(diff), diff)
+	}
+
+	opts := deep.DefaultOptions
+	opts.PathRules = map[string]deep.PathRule{
+		"Price.Currency": {CaseInsensitive: true},
+	}
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Price.Note: paid != PAID" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	b.Price.Currency = "EUR"
+	b.Price.Note = "paid"
+	diff, _ = deep.CompareS(a, b, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Price.Currency: usd != EUR" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+// message is a union of textMsg and imageMsg for TestUnionVariant.
+type message interface {
+	isMessage()
+}
+
+type textMsg struct {
+	Text string
+}
+
+type imageMsg struct {
+	URL    string
+	Width  int
+	Height int
+}
+
+func (textMsg) isMessage()  {}
+func (imageMsg) isMessage() {}
+
+func TestUnionVariant(t *testing.T) {
+	type envelope struct {
+		ID      int
+		Payload message
+	}
+	a := envelope{ID: 1, Payload: textMsg{Text: "hi"}}
+	b := envelope{ID: 1, Payload: imageMsg{URL: "x.png"}}
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Payload: variant deep_test.textMsg -> deep_test.imageMsg" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Same variant
+	b.Payload = textMsg{Text: "hello"}
+	diff, _ = deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "Payload.Text: hi != hello" {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	a := "line 1\r\nline 2\r\n"
+	b := "line 1\nline 2\n"
+
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+
+	opts := deep.DefaultOptions
+	opts.NormalizeLineEndings = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS("line 1\rline 2", "line 1\nline 2", opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	// Diffs show the original strings
+	diff, _ = deep.CompareS("a\r\nb", "a\nc", opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "a\r\nb != a\nc" {
+		t.Errorf("wrong diff: %q", diff[0])
+	}
+}
+
+func TestZeroTimeEqualsNil(t *testing.T) {
+	type T struct {
+		DeletedAt *time.Time
+	}
+	zero := time.Time{}
+	a := T{DeletedAt: nil}
+	b := T{DeletedAt: &zero}
+
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+
+	opts := deep.DefaultOptions
+	opts.ZeroTimeEqualsNil = true
+	diff, _ = deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+	diff, _ = deep.CompareS(b, a, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	// Other times still differ from nil
+	now := time.Now()
+	diff, _ = deep.CompareS(a, T{DeletedAt: &now}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != "DeletedAt: <nil pointer> != time.Time" {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Other zero values still differ from nil
+	type U struct {
+		N *int
+	}
+	var n int
+	diff, _ = deep.CompareS(U{}, U{N: &n}, opts)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+}
+
+func TestMapValueTypeChange(t *testing.T) {
+	a := map[string]interface{}{"foo": 1, "bar": 2}
+	b := map[string]interface{}{"foo": "1", "bar": 2}
+	diff, _ := deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != `foo: type int -> string (1 -> "1")` {
+		t.Error("wrong diff:", diff[0])
+	}
+
+	// Nested maps
+	a = map[string]interface{}{"cfg": map[string]interface{}{"on": true}}
+	b = map[string]interface{}{"cfg": map[string]interface{}{"on": "yes"}}
+	diff, _ = deep.CompareS(a, b)
+	if diff == nil {
+		t.Fatal("no diff")
+	}
+	if len(diff) != 1 {
+		t.Error("too many diff:", diff)
+	}
+	if diff[0] != `cfg.on: type bool -> string (true -> "yes")` {
+		t.Error("wrong diff:", diff[0])
+	}
+}
+
+func TestMapKeysOnly(t *testing.T) {
+	a := map[string]interface{}{"name": "foo", "tags": []string{"x"}, "meta": map[string]int{"v": 1}}
+	b := map[string]interface{}{"name": "bar", "tags": nil, "meta": map[string]int{"v": 2, "w": 3}}
+
+	opts := deep.DefaultOptions
+	opts.MapKeysOnly = true
+	diff, _ := deep.CompareS(a, b, opts)
+	if len(diff) > 0 {
+		t.Error("should be equal:", diff)
+	}
+
+	delete(b, "tags")
+	b["id"] = 1
+	opts.DeterministicMapOrder = true
+	diff, _ = deep.CompareS(a, b, opts)
+	expect := []string{
+		"tags: [x] != [empty value]",
+		"id: [empty value] != 1",
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("got %q, expected %q", diff, expect)
+	}
+}
@@ -0,0 +1,112 @@
+// Package deepassert is a fluent assertion API for tests built on deep.CompareS:
+//
+//	deepassert.Expect(t, got).ToEqual(want).WithFloatPrecision(6).IgnoringFields("CreatedAt")
+//
+// The values are compared when the test finishes, after the assertion is
+// configured, or when Check is called. The test fails with the differences,
+// one per line, if there are any.
+package deepassert
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/chaelub/deep"
+)
+
+// TestingT is the part of testing.TB used by assertions.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// Expectation is a value to make an assertion about, returned by Expect.
+type Expectation struct {
+	t   TestingT
+	got interface{}
+}
+
+// Expect returns an expectation about got in test t.
+func Expect(t TestingT, got interface{}) *Expectation {
+	return &Expectation{t: t, got: got}
+}
+
+// ToEqual asserts that the value equals want. It returns the assertion to
+// configure it, like a.WithFloatPrecision(6). The values are compared when
+// the test finishes unless Check is called first.
+func (e *Expectation) ToEqual(want interface{}) *Assertion {
+	e.t.Helper()
+	a := &Assertion{
+		t:    e.t,
+		got:  e.got,
+		want: want,
+		opts: deep.DefaultOptions,
+	}
+	e.t.Cleanup(func() { a.Check() })
+	return a
+}
+
+// Assertion is an assertion that two values are equal, returned by
+// Expectation.ToEqual.
+type Assertion struct {
+	t       TestingT
+	got     interface{}
+	want    interface{}
+	opts    deep.Options
+	ignored map[string]bool
+	checked bool
+	equal   bool
+}
+
+// WithOptions sets the options to compare the values with. It replaces options
+// set by previous calls, like WithFloatPrecision.
+func (a *Assertion) WithOptions(opts deep.Options) *Assertion {
+	a.opts = opts
+	return a
+}
+
+// WithFloatPrecision sets the number of decimal places to round float values
+// to when comparing, like deep.Options.FloatPrecision.
+func (a *Assertion) WithFloatPrecision(n int) *Assertion {
+	a.opts.FloatPrecision = n
+	return a
+}
+
+// IgnoringFields ignores struct fields with the given Go names, like
+// "CreatedAt", at any depth, whatever their compare tags. Map keys are not
+// fields, so they are compared.
+func (a *Assertion) IgnoringFields(names ...string) *Assertion {
+	if a.ignored == nil {
+		a.ignored = map[string]bool{}
+	}
+	for _, name := range names {
+		a.ignored[name] = true
+	}
+	return a
+}
+
+// Check compares the values and fails the test if they differ. It returns
+// true if they are equal. The values are compared once; later calls return
+// the same result.
+func (a *Assertion) Check() bool {
+	a.t.Helper()
+	if a.checked {
+		return a.equal
+	}
+	a.checked = true
+
+	opts := a.opts
+	if len(a.ignored) > 0 {
+		ignoreField := opts.IgnoreField
+		opts.IgnoreField = func(f reflect.StructField) bool {
+			return a.ignored[f.Name] || ignoreField != nil && ignoreField(f)
+		}
+	}
+	diff, _ := deep.CompareS(a.got, a.want, opts)
+	if a.equal = len(diff) == 0; a.equal {
+		return true
+	}
+	a.t.Errorf("values differ (got != want):\n\t%s", strings.Join(diff, "\n\t"))
+	return false
+}
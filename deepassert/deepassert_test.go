@@ -0,0 +1,138 @@
+package deepassert_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chaelub/deep/deepassert"
+)
+
+// recorder is a deepassert.TestingT that records failures.
+type recorder struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+// finish runs the cleanups like when a test finishes.
+func (r *recorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+type user struct {
+	Name      string
+	Score     float64
+	CreatedAt time.Time
+}
+
+func TestToEqual(t *testing.T) {
+	got := user{Name: "foo", Score: 1.0000001, CreatedAt: time.Now()}
+	want := user{Name: "foo", Score: 1}
+
+	r := &recorder{}
+	deepassert.Expect(r, got).ToEqual(want).WithFloatPrecision(6).IgnoringFields("CreatedAt")
+	r.finish()
+	if len(r.errors) > 0 {
+		t.Error("should be equal:", r.errors)
+	}
+
+	r = &recorder{}
+	deepassert.Expect(r, got).ToEqual(want).IgnoringFields("CreatedAt")
+	r.finish()
+	if len(r.errors) != 1 {
+		t.Fatalf("got %d errors, expected 1: %q", len(r.errors), r.errors)
+	}
+	expect := "values differ (got != want):\n\tScore: 1.0000001 != 1"
+	if r.errors[0] != expect {
+		t.Errorf("got %q, expected %q", r.errors[0], expect)
+	}
+}
+
+func TestIgnoringFieldsMapKeys(t *testing.T) {
+	r := &recorder{}
+	got := map[string]int{"CreatedAt": 1}
+	deepassert.Expect(r, got).ToEqual(map[string]int{}).IgnoringFields("CreatedAt")
+	r.finish()
+	if len(r.errors) != 1 {
+		t.Fatalf("got %d errors, expected 1: %q", len(r.errors), r.errors)
+	}
+	expect := "values differ (got != want):\n\tCreatedAt: 1 != [empty value]"
+	if r.errors[0] != expect {
+		t.Errorf("got %q, expected %q", r.errors[0], expect)
+	}
+
+	// Keys in both maps are compared too
+	r = &recorder{}
+	deepassert.Expect(r, got).ToEqual(map[string]int{"CreatedAt": 2}).IgnoringFields("CreatedAt")
+	r.finish()
+	if len(r.errors) != 1 {
+		t.Fatalf("got %d errors, expected 1: %q", len(r.errors), r.errors)
+	}
+	expect = "values differ (got != want):\n\tCreatedAt: 1 != 2"
+	if r.errors[0] != expect {
+		t.Errorf("got %q, expected %q", r.errors[0], expect)
+	}
+}
+
+func TestIgnoringFieldsTagged(t *testing.T) {
+	type event struct {
+		Name      string
+		CreatedAt time.Time `compare:"created_at"`
+	}
+	r := &recorder{}
+	got := event{Name: "foo", CreatedAt: time.Now()}
+	deepassert.Expect(r, got).ToEqual(event{Name: "foo"}).IgnoringFields("CreatedAt")
+	r.finish()
+	if len(r.errors) > 0 {
+		t.Error("should be equal:", r.errors)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	r := &recorder{}
+	a := deepassert.Expect(r, user{Name: "foo"}).ToEqual(user{Name: "bar"})
+	if a.Check() {
+		t.Error("Check returned true, expected false")
+	}
+	if len(r.errors) != 1 {
+		t.Fatalf("got %d errors, expected 1: %q", len(r.errors), r.errors)
+	}
+
+	// Not compared again when the test finishes
+	r.finish()
+	if len(r.errors) != 1 {
+		t.Errorf("got %d errors, expected 1: %q", len(r.errors), r.errors)
+	}
+	if a.Check() {
+		t.Error("Check returned true, expected false")
+	}
+}
+
+// printer is a deepassert.TestingT that prints failures.
+type printer struct{ recorder }
+
+func (p *printer) Errorf(format string, args ...interface{}) {
+	fmt.Printf(format+"\n", args...)
+}
+
+func ExampleExpect() {
+	t := &printer{} // *testing.T in a test
+	got := user{Name: "foo", Score: 2.5, CreatedAt: time.Now()}
+	want := user{Name: "bar", Score: 2.5}
+	deepassert.Expect(t, got).ToEqual(want).IgnoringFields("CreatedAt").Check()
+	// Output:
+	// values differ (got != want):
+	// 	Name: foo != bar
+}